@@ -0,0 +1,285 @@
+package buffer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/azer/logger"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/errors"
+)
+
+// Default flush thresholds
+const (
+	defaultFlushSize     = 1000
+	defaultFlushInterval = time.Second
+)
+
+// Failed segments are retried with an exponential backoff, up to maxRetryDelay,
+// and moved to the failed directory after maxFlushAttempts
+const (
+	maxFlushAttempts = 10
+	maxRetryDelay    = time.Minute
+)
+
+type Opts struct {
+	// Directory of the log segments
+	Directory string
+	// Number of buffered analytics triggering a flush
+	FlushSize int
+	// Maximum time spent by an analytic in the buffer
+	FlushInterval time.Duration
+	// Flush and close the buffer when receiving on it
+	ClosingChannel chan bool
+	// Sent to once the buffer is closed
+	ClosedChannel chan bool
+}
+
+// Buffer acknowledges inserts as soon as they are written to a local log,
+// and inserts them in the driver by batches
+// Analytics are inserted at least once: a crash during a flush may insert
+// a batch again when replaying the log
+type Buffer struct {
+	database.Driver
+
+	opts   Opts
+	logger *logger.Logger
+
+	// Analytics written to the current segment
+	mutex   sync.Mutex
+	log     *segmentLog
+	pending map[string][]database.Analytic
+	count   int
+
+	// Segments waiting to be inserted, in order
+	flushMutex sync.Mutex
+	unflushed  []*segment
+	// Failed attempts to insert the first segment and time of the next one
+	attempts int
+	retryAt  time.Time
+
+	flushes chan struct{}
+	closing chan struct{}
+	closed  chan struct{}
+}
+
+// Create a Buffer in front of driver
+// Segments left by a previous run are replayed first
+func New(driver database.Driver, opts Opts) (*Buffer, error) {
+	if opts.FlushSize < 1 {
+		opts.FlushSize = defaultFlushSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+
+	log, unflushed, err := openSegmentLog(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &Buffer{
+		Driver:    driver,
+		opts:      opts,
+		logger:    logger.New("[Buffer]"),
+		log:       log,
+		pending:   make(map[string][]database.Analytic),
+		unflushed: unflushed,
+		flushes:   make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+
+	// Replay segments of previous run
+	if len(unflushed) > 0 {
+		buffer.logger.Info("Replaying %d log segments from %s", len(unflushed), opts.Directory)
+		if err := buffer.Flush(); err != nil {
+			buffer.logger.Error("Error replaying log segments: %v", err)
+		}
+	}
+
+	go buffer.run()
+
+	// Handle closing buffer when app is killed
+	if opts.ClosingChannel != nil {
+		go func() {
+			<-opts.ClosingChannel
+			if err := buffer.Close(); err != nil {
+				buffer.logger.Error("Error closing buffer: %v", err)
+			}
+			if opts.ClosedChannel != nil {
+				opts.ClosedChannel <- true
+			}
+		}()
+	}
+
+	return buffer, nil
+}
+
+// Buffer a new analytic
+func (buffer *Buffer) Insert(ctx context.Context, params database.Params, analytic database.Analytic) error {
+	return buffer.BulkInsert(ctx, map[string][]database.Analytic{
+		params.DBName: []database.Analytic{analytic},
+	}, false)
+}
+
+// Buffer new analytics
+// They are written to the log at once before returning, but may be inserted
+// along with other analytics, so atomic inserts are rejected
+func (buffer *Buffer) BulkInsert(ctx context.Context, analytics map[string][]database.Analytic, atomic bool) error {
+	if atomic {
+		return &errors.AtomicNotSupported
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	if err := buffer.log.Append(analytics); err != nil {
+		buffer.logger.Error("Error appending to log: %v", err)
+		return err
+	}
+
+	for dbName, dbAnalytics := range analytics {
+		buffer.pending[dbName] = append(buffer.pending[dbName], dbAnalytics...)
+		buffer.count += len(dbAnalytics)
+	}
+
+	// Ask for a flush if buffer is full
+	if buffer.count >= buffer.opts.FlushSize {
+		select {
+		case buffer.flushes <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Flush buffered analytics before removing a DB
+// so that they don't recreate it later on
+func (buffer *Buffer) Delete(ctx context.Context, params database.Params) error {
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+	return buffer.Driver.Delete(ctx, params)
+}
+
+// Insert buffered analytics in the driver
+// Failed analytics of a segment are kept and retried on next flush,
+// until the segment is moved to the failed directory after maxFlushAttempts
+func (buffer *Buffer) Flush() error {
+	buffer.flushMutex.Lock()
+	defer buffer.flushMutex.Unlock()
+
+	// Close current segment
+	buffer.mutex.Lock()
+	if buffer.count > 0 {
+		current, err := buffer.log.Rotate()
+		if current == nil {
+			buffer.mutex.Unlock()
+			return err
+		}
+		if err != nil {
+			buffer.logger.Error("Error closing log segment %s: %v", current.Path, err)
+		}
+		current.Analytics = buffer.pending
+		buffer.unflushed = append(buffer.unflushed, current)
+
+		buffer.pending = make(map[string][]database.Analytic)
+		buffer.count = 0
+	}
+	buffer.mutex.Unlock()
+
+	// Insert segments in order
+	for len(buffer.unflushed) > 0 {
+		s := buffer.unflushed[0]
+		if err := buffer.Driver.BulkInsert(context.Background(), s.Analytics, false); err != nil {
+			// Only retry failed analytics, so that inserted ones aren't inserted twice
+			if bulkErr, ok := err.(*errors.BulkInsertError); ok && len(bulkErr.Failed) > 0 {
+				if err := s.Keep(bulkErr.Failed); err != nil {
+					buffer.logger.Error("Error rewriting log segment %s: %v", s.Path, err)
+				}
+			}
+
+			buffer.attempts++
+			if buffer.attempts < maxFlushAttempts {
+				buffer.retryAt = time.Now().Add(retryDelay(buffer.opts.FlushInterval, buffer.attempts))
+				return err
+			}
+
+			// Don't let a segment block the following ones
+			buffer.logger.Error("Giving up inserting log segment %s after %d attempts: %v", s.Path, buffer.attempts, err)
+			if err := s.MoveTo(failedDirectory(buffer.opts.Directory)); err != nil {
+				buffer.logger.Error("Error moving log segment %s: %v", s.Path, err)
+			}
+		} else if err := s.Remove(); err != nil {
+			buffer.logger.Error("Error removing log segment %s: %v", s.Path, err)
+		}
+
+		buffer.unflushed = buffer.unflushed[1:]
+		buffer.attempts = 0
+		buffer.retryAt = time.Time{}
+	}
+
+	return nil
+}
+
+// Delay before retrying a segment after attempts failures
+// Doubles from interval after each failure, up to maxRetryDelay
+func retryDelay(interval time.Duration, attempts int) time.Duration {
+	delay := interval
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// Flush remaining analytics and stop flushing
+func (buffer *Buffer) Close() error {
+	close(buffer.closing)
+	<-buffer.closed
+
+	err := buffer.Flush()
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+	if closeErr := buffer.log.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Flush when buffer is full or every FlushInterval
+func (buffer *Buffer) run() {
+	defer close(buffer.closed)
+
+	ticker := time.NewTicker(buffer.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-buffer.closing:
+			return
+		case <-buffer.flushes:
+		case <-ticker.C:
+		}
+
+		// Wait before retrying a failed segment
+		buffer.flushMutex.Lock()
+		retryAt := buffer.retryAt
+		buffer.flushMutex.Unlock()
+		if time.Now().Before(retryAt) {
+			continue
+		}
+
+		if err := buffer.Flush(); err != nil {
+			buffer.logger.Error("Error flushing buffer: %v", err)
+		}
+	}
+}
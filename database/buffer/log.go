@@ -0,0 +1,260 @@
+package buffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Extension of log segments files
+const segmentExt = ".log"
+
+// A line of a log segment
+type entry struct {
+	DBName string `json:"db"`
+	database.Analytic
+}
+
+// A log file of buffered analytics
+type segment struct {
+	Path      string
+	Analytics map[string][]database.Analytic
+}
+
+func (s *segment) Remove() error {
+	return os.Remove(s.Path)
+}
+
+// Keep only the analytics at positions in the list of their DB
+// The segment file is rewritten then replaced at once,
+// so that a crash leaves either the previous or the new file
+func (s *segment) Keep(positions map[string][]int) error {
+	analytics := make(map[string][]database.Analytic)
+	for dbName, dbPositions := range positions {
+		sort.Ints(dbPositions)
+		for _, i := range dbPositions {
+			analytics[dbName] = append(analytics[dbName], s.Analytics[dbName][i])
+		}
+	}
+	s.Analytics = analytics
+
+	data, err := encodeEntries(analytics)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.Path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.Path)
+}
+
+// Move the segment file to directory, out of the replayed segments
+func (s *segment) MoveTo(directory string) error {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(s.Path, path.Join(directory, path.Base(s.Path)))
+}
+
+// Directory of the segments which failed to be inserted
+func failedDirectory(directory string) string {
+	return path.Join(directory, "failed")
+}
+
+// Append-only log of analytics, split into numbered segments
+type segmentLog struct {
+	directory string
+	sequence  int
+	file      *os.File
+}
+
+// Open a new segment in directory
+// Segments left in directory are loaded and returned in order
+func openSegmentLog(directory string) (*segmentLog, []*segment, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, nil, err
+	}
+
+	paths, err := filepath.Glob(path.Join(directory, "*"+segmentExt))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Names are zero-padded, so sorting them sorts segments
+	sort.Strings(paths)
+
+	log := &segmentLog{
+		directory: directory,
+	}
+
+	// Failed segments keep their name, so numbering continues after them
+	failedPaths, err := filepath.Glob(path.Join(failedDirectory(directory), "*"+segmentExt))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range failedPaths {
+		if sequence, ok := segmentSequence(p); ok && sequence > log.sequence {
+			log.sequence = sequence
+		}
+	}
+
+	segments := make([]*segment, 0, len(paths))
+	for _, p := range paths {
+		sequence, ok := segmentSequence(p)
+		if !ok {
+			continue
+		}
+		if sequence > log.sequence {
+			log.sequence = sequence
+		}
+
+		s, err := readSegment(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		segments = append(segments, s)
+	}
+
+	file, err := log.create(log.sequence + 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.sequence++
+	log.file = file
+
+	return log, segments, nil
+}
+
+// Return the sequence number of a segment from its file name
+func segmentSequence(p string) (int, bool) {
+	var sequence int
+	if _, err := fmt.Sscanf(path.Base(p), "%d"+segmentExt, &sequence); err != nil {
+		return 0, false
+	}
+	return sequence, true
+}
+
+// Read analytics of a segment
+// A partially written last line, e.g. after a crash, is ignored
+func readSegment(p string) (*segment, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s := &segment{
+		Path:      p,
+		Analytics: make(map[string][]database.Analytic),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		s.Analytics[e.DBName] = append(s.Analytics[e.DBName], e.Analytic)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Create the segment numbered sequence
+func (log *segmentLog) create(sequence int) (*os.File, error) {
+	name := fmt.Sprintf("%020d%s", sequence, segmentExt)
+	return os.OpenFile(path.Join(log.directory, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// Encode analytics as lines of a segment
+func encodeEntries(analytics map[string][]database.Analytic) ([]byte, error) {
+	data := make([]byte, 0)
+	for dbName, dbAnalytics := range analytics {
+		for _, analytic := range dbAnalytics {
+			line, err := json.Marshal(entry{DBName: dbName, Analytic: analytic})
+			if err != nil {
+				return nil, err
+			}
+			data = append(append(data, line...), '\n')
+		}
+	}
+	return data, nil
+}
+
+// Write analytics to the current segment and sync it to disk
+// Analytics are written at once, so that a crash only leaves a partial last line
+func (log *segmentLog) Append(analytics map[string][]database.Analytic) error {
+	data, err := encodeEntries(analytics)
+	if err != nil {
+		return err
+	}
+
+	if _, err := log.file.Write(data); err != nil {
+		return err
+	}
+
+	return log.file.Sync()
+}
+
+// Open the next segment and close the current one
+// The closed segment is returned without its analytics
+// The next segment is opened first, so that the log always has a segment to
+// write to, and the closed one is returned even if closing it failed,
+// its analytics being synced on every write
+func (log *segmentLog) Rotate() (*segment, error) {
+	file, err := log.create(log.sequence + 1)
+	if err != nil {
+		return nil, err
+	}
+	log.sequence++
+
+	current := &segment{
+		Path: log.file.Name(),
+	}
+	err = log.file.Close()
+	log.file = file
+
+	return current, err
+}
+
+// Close the current segment, removing it if empty
+func (log *segmentLog) Close() error {
+	info, err := log.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := log.file.Close(); err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		return os.Remove(log.file.Name())
+	}
+	return nil
+}
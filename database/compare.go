@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// Time ranges results can be compared with
+const (
+	ComparePrevious = "previous"
+	CompareYear     = "year"
+)
+
+var ErrInvalidCompare = errors.New("comparison requires a start time")
+
+// Values of a result over the compared time range, and their changes
+// Percents are null if the previous value is 0
+type Comparison struct {
+	Total         int      `json:"total"`
+	Unique        int      `json:"unique"`
+	TotalChange   int      `json:"totalChange"`
+	TotalPercent  *float64 `json:"totalPercent"`
+	UniqueChange  int      `json:"uniqueChange"`
+	UniquePercent *float64 `json:"uniquePercent"`
+}
+
+// Compare current values with previous ones
+func NewComparison(total int, unique int, previousTotal int, previousUnique int) *Comparison {
+	return &Comparison{
+		Total:         previousTotal,
+		Unique:        previousUnique,
+		TotalChange:   total - previousTotal,
+		TotalPercent:  changePercent(total, previousTotal),
+		UniqueChange:  unique - previousUnique,
+		UniquePercent: changePercent(unique, previousUnique),
+	}
+}
+
+func changePercent(value int, previous int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	percent := float64(value-previous) * 100 / float64(previous)
+	return &percent
+}
+
+// Shift from a time range to the one it is compared with
+type TimeShift struct {
+	Duration time.Duration
+	Days     int
+	Years    int
+}
+
+// Return the TimeShift to compare timeRange with, according to compare
+// Open time ranges end at now
+// Weekly time series are compared with 52 weeks before, to compare the same days of week
+func NewTimeShift(compare string, timeRange *TimeRange, interval SeriesInterval, now time.Time) (*TimeShift, error) {
+	if timeRange == nil || timeRange.Start.IsZero() {
+		return nil, ErrInvalidCompare
+	}
+
+	switch compare {
+	case ComparePrevious:
+		// Shifting by the length of the time range keeps intervals aligned,
+		// the compared time range is cut before the current one by TimeRange
+		end := timeRange.End
+		if end.IsZero() {
+			end = now
+		}
+		return &TimeShift{Duration: end.Sub(timeRange.Start)}, nil
+	case CompareYear:
+		if interval.Calendar == IntervalWeek {
+			return &TimeShift{Days: 52 * 7}, nil
+		}
+		return &TimeShift{Years: 1}, nil
+	}
+
+	return nil, ErrInvalidCompare
+}
+
+// Return t shifted to the compared time range
+func (shift TimeShift) Apply(t time.Time) time.Time {
+	return t.Add(-shift.Duration).AddDate(-shift.Years, 0, -shift.Days)
+}
+
+// Return the compared time range
+// Previous time ranges stop right before the current one
+func (shift TimeShift) TimeRange(timeRange *TimeRange, now time.Time) *TimeRange {
+	end := timeRange.End
+	if end.IsZero() {
+		end = now
+	}
+
+	shifted := TimeRange{
+		Start: shift.Apply(timeRange.Start),
+		End:   shift.Apply(end),
+	}
+	if shift.Duration > 0 && !shifted.End.Before(timeRange.Start) {
+		shifted.End = timeRange.Start.Add(-time.Second)
+	}
+
+	return &shifted
+}
+
+// Set the comparison of each Aggregate with the previous one of the same Key
+func (aggregates *Aggregates) Compare(previous Aggregates) {
+	previousByKey := make(map[string]Aggregate, len(previous.List))
+	for _, aggregate := range previous.List {
+		previousByKey[aggregate.Key()] = aggregate
+	}
+
+	for i, aggregate := range aggregates.List {
+		p := previousByKey[aggregate.Key()]
+		aggregates.List[i].Previous = NewComparison(aggregate.Total, aggregate.Unique, p.Total, p.Unique)
+	}
+}
+
+// Set the comparison of each Interval with the previous one containing its shifted Start
+func (intervals *Intervals) Compare(previous Intervals, interval SeriesInterval, shift TimeShift) {
+	previousByStart := make(map[int64]Interval, len(previous.List))
+	for _, i := range previous.List {
+		start, err := time.Parse(time.RFC3339, i.Start)
+		if err != nil {
+			continue
+		}
+		previousByStart[start.Unix()] = i
+	}
+
+	for i, current := range intervals.List {
+		start, err := time.Parse(time.RFC3339, current.Start)
+		if err != nil {
+			continue
+		}
+		p := previousByStart[interval.Start(shift.Apply(start)).Unix()]
+		intervals.List[i].Previous = NewComparison(current.Total, current.Unique, p.Total, p.Unique)
+	}
+}
+
+// Set the comparison of Count with the previous one
+func (count *Count) Compare(previous Count) {
+	count.Previous = NewComparison(count.Total, count.Unique, previous.Total, previous.Unique)
+}
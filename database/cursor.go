@@ -0,0 +1,44 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Orders supported when listing Analytics
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Cursor is the position of an Analytic in a DB
+// Analytics are ordered by time, then by insertion order in their shard
+type Cursor struct {
+	Shard string `json:"s,omitempty"`
+	Time  int64  `json:"t"`
+	RowId int64  `json:"r"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Encode Cursor as an opaque token, safe for URLs
+func (cursor Cursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode a token returned by Cursor.Encode
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := Cursor{}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCursorEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"zero", Cursor{}},
+		{"first analytic of a shard", Cursor{Shard: "2016-01", Time: 1451606400, RowId: 1}},
+		{"last analytic of a shard", Cursor{Shard: "2015-12", Time: 1451606399, RowId: 123456}},
+		{"without shard", Cursor{Time: 1448020800, RowId: 42}},
+		{"before epoch", Cursor{Shard: "1969-12", Time: -1, RowId: 1}},
+	}
+
+	for _, test := range tests {
+		token := test.cursor.Encode()
+		decoded, err := DecodeCursor(token)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *decoded != test.cursor {
+			t.Errorf("%s: decoded %+v, want %+v", test.name, *decoded, test.cursor)
+		}
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"t":1}`))},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("cursor"))},
+		{"wrong types", base64.RawURLEncoding.EncodeToString([]byte(`{"s":1,"t":"x"}`))},
+	}
+
+	for _, test := range tests {
+		if _, err := DecodeCursor(test.token); err != ErrInvalidCursor {
+			t.Errorf("%s: error %v, want %v", test.name, err, ErrInvalidCursor)
+		}
+	}
+}
@@ -1,20 +1,31 @@
 package database
 
+import (
+	"context"
+)
+
+// Every method takes a context, canceling it stops the running queries
 type Driver interface {
 	// Count number of stats
-	Count(params Params) (*Count, error)
+	Count(ctx context.Context, params Params) (*Count, error)
 	// Return aggregated stats by property
-	GroupBy(params Params) (*Aggregates, error)
+	GroupBy(ctx context.Context, params Params) (*Aggregates, error)
 	// Return time serie sliced by a specific interval
-	Series(params Params) (*Intervals, error)
+	Series(ctx context.Context, params Params) (*Intervals, error)
+	// Return metrics of the sessions of visitors
+	Sessions(ctx context.Context, params Params) (*Sessions, error)
+	// Return the number of visitors reaching each step of a funnel
+	Funnel(ctx context.Context, params Params) (*Funnel, error)
 	// Return all stats
-	Query(params Params) (*Analytics, error)
+	Query(ctx context.Context, params Params) (*Analytics, error)
+	// Call fn for each stat in time order, without loading them all in memory
+	Stream(ctx context.Context, params Params, fn func(Analytic) error) error
 	// Handle adding new stats
-	Insert(params Params, analytic Analytic) error
-	// Handle bulk insert
-	BulkInsert(analytics map[string][]Analytic) error
+	Insert(ctx context.Context, params Params, analytic Analytic) error
+	// Handle bulk insert, inserting all stats or none of them if atomic
+	BulkInsert(ctx context.Context, analytics map[string][]Analytic, atomic bool) error
 	// Handle DB removal
-	Delete(params Params) error
+	Delete(ctx context.Context, params Params) error
 }
 
 type DriverOpts struct {
@@ -23,4 +34,5 @@ type DriverOpts struct {
 	IdleTimeout    int
 	CacheDirectory string
 	ClosingChannel chan bool
+	QueryWorkers   int
 }
@@ -21,3 +21,18 @@ func Errorf(code int, err string, a ...interface{}) *DriverError {
 		Message: fmt.Sprintf(err, a...),
 	}
 }
+
+// Error of a bulk insert failing for some of the analytics
+// Failed maps DB names to the positions of failed analytics in their list
+type BulkInsertError struct {
+	DriverError
+	Failed map[string][]int
+}
+
+// Record the failure of analytics at positions in dbName
+func (e *BulkInsertError) Fail(dbName string, positions []int) {
+	if e.Failed == nil {
+		e.Failed = make(map[string][]int)
+	}
+	e.Failed[dbName] = append(e.Failed[dbName], positions...)
+}
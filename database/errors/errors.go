@@ -14,3 +14,28 @@ var InsertFailed = DriverError{
 	Code:    3,
 	Message: "Failed to insert into DB",
 }
+
+var QueryTimeout = DriverError{
+	Code:    4,
+	Message: "Query timed out",
+}
+
+var QueryCanceled = DriverError{
+	Code:    5,
+	Message: "Query was canceled",
+}
+
+var InvalidCursor = DriverError{
+	Code:    6,
+	Message: "Invalid cursor",
+}
+
+var TooManyIntervals = DriverError{
+	Code:    7,
+	Message: "Too many intervals in time series",
+}
+
+var AtomicNotSupported = DriverError{
+	Code:    8,
+	Message: "Atomic inserts are not supported",
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"time"
+)
+
+// Default time allowed to go through every step of a funnel from its first one
+const DefaultFunnelWindow = 24 * time.Hour
+
+// Maximum number of steps of a funnel
+const MaxFunnelSteps = 10
+
+// An analytic matches a step if it has both its Event and its Path
+// An empty Event or Path matches any value
+type FunnelStep struct {
+	Event string `json:"event,omitempty"`
+	Path  string `json:"path,omitempty"`
+}
+
+// Check whether an analytic matches the step
+func (step FunnelStep) Match(event string, path string) bool {
+	if len(step.Event) > 0 && step.Event != event {
+		return false
+	}
+	if len(step.Path) > 0 && step.Path != path {
+		return false
+	}
+	return true
+}
+
+// Number of visitors who reached a step of a funnel
+// ConversionRate is relative to the first step, DropOffRate to the previous one
+type FunnelStepResult struct {
+	Event          string  `json:"event,omitempty"`
+	Path           string  `json:"path,omitempty"`
+	Count          int     `json:"count"`
+	ConversionRate float64 `json:"conversionRate"`
+	DropOffRate    float64 `json:"dropOffRate"`
+}
+
+type Funnel struct {
+	Steps []FunnelStepResult `json:"steps"`
+}
+
+// An analytic matching some steps of a funnel
+// Steps is a bitmask of the indexes of the matched steps
+type FunnelEvent struct {
+	Time  int64 `json:"time"`
+	Steps uint  `json:"steps"`
+}
+
+// Analytics matching steps of a funnel, by visitor and in time order
+type FunnelEvents map[string][]FunnelEvent
+
+// Append the analytics of a later shard
+func (events FunnelEvents) Merge(other FunnelEvents) {
+	for visitor, visitorEvents := range other {
+		events[visitor] = append(events[visitor], visitorEvents...)
+	}
+}
+
+// Count the visitors reaching each step of a funnel in order,
+// within window from their first step
+func (events FunnelEvents) Funnel(steps []FunnelStep, window time.Duration) *Funnel {
+	counts := make([]int, len(steps))
+	for _, visitorEvents := range events {
+		reached := funnelReachedSteps(visitorEvents, len(steps), int64(window.Seconds()))
+		for i := 0; i < reached; i++ {
+			counts[i]++
+		}
+	}
+
+	funnel := Funnel{
+		Steps: make([]FunnelStepResult, len(steps)),
+	}
+	for i, step := range steps {
+		result := FunnelStepResult{
+			Event: step.Event,
+			Path:  step.Path,
+			Count: counts[i],
+		}
+		if counts[0] > 0 {
+			result.ConversionRate = float64(counts[i]) / float64(counts[0])
+		}
+		if i > 0 && counts[i-1] > 0 {
+			result.DropOffRate = 1 - float64(counts[i])/float64(counts[i-1])
+		}
+		funnel.Steps[i] = result
+	}
+
+	return &funnel
+}
+
+// Return the number of steps reached in order by a visitor within window seconds
+// The latest start of a sequence reaching each step is kept,
+// as it leaves the most time to reach the following steps
+func funnelReachedSteps(visitorEvents []FunnelEvent, stepsCount int, window int64) int {
+	starts := make([]int64, stepsCount)
+	reached := 0
+
+	for _, event := range visitorEvents {
+		// Go through steps backwards so that an analytic matching
+		// consecutive steps only counts for one of them
+		for i := stepsCount - 1; i >= 0; i-- {
+			if event.Steps&(1<<uint(i)) == 0 {
+				continue
+			}
+
+			if i == 0 {
+				starts[0] = event.Time
+			} else if i <= reached && event.Time-starts[i-1] <= window {
+				if i == reached || starts[i-1] > starts[i] {
+					starts[i] = starts[i-1]
+				}
+			} else {
+				continue
+			}
+
+			if i+1 > reached {
+				reached = i + 1
+			}
+		}
+
+		if reached == stepsCount {
+			break
+		}
+	}
+
+	return reached
+}
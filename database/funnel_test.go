@@ -0,0 +1,107 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// Analytic at time matching the steps of their indexes
+func funnelEvent(time int64, steps ...uint) FunnelEvent {
+	event := FunnelEvent{Time: time}
+	for _, step := range steps {
+		event.Steps |= 1 << step
+	}
+	return event
+}
+
+func TestFunnelStepMatch(t *testing.T) {
+	tests := []struct {
+		step  FunnelStep
+		event string
+		path  string
+		match bool
+	}{
+		{FunnelStep{}, "click", "/a", true},
+		{FunnelStep{Event: "click"}, "click", "/a", true},
+		{FunnelStep{Event: "click"}, "view", "/a", false},
+		{FunnelStep{Path: "/a"}, "click", "/a", true},
+		{FunnelStep{Path: "/a"}, "click", "/b", false},
+		{FunnelStep{Event: "click", Path: "/a"}, "click", "/a", true},
+		{FunnelStep{Event: "click", Path: "/a"}, "click", "/b", false},
+	}
+
+	for _, test := range tests {
+		if test.step.Match(test.event, test.path) != test.match {
+			t.Errorf("%+v matching %s %s: want %v", test.step, test.event, test.path, test.match)
+		}
+	}
+}
+
+func TestFunnelReachedSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []FunnelEvent
+		reached int
+	}{
+		{"no events", nil, 0},
+		{"in order", []FunnelEvent{funnelEvent(0, 0), funnelEvent(10, 1), funnelEvent(20, 2)}, 3},
+		{"same time", []FunnelEvent{funnelEvent(5, 0), funnelEvent(5, 1), funnelEvent(5, 2)}, 3},
+		{"second step first", []FunnelEvent{funnelEvent(0, 1), funnelEvent(10, 0), funnelEvent(20, 2)}, 1},
+		{"skipped step", []FunnelEvent{funnelEvent(0, 0), funnelEvent(10, 2)}, 1},
+		{"only later steps", []FunnelEvent{funnelEvent(0, 1), funnelEvent(10, 2)}, 0},
+		{"last step at window", []FunnelEvent{funnelEvent(0, 0), funnelEvent(50, 1), funnelEvent(100, 2)}, 3},
+		{"last step after window", []FunnelEvent{funnelEvent(0, 0), funnelEvent(50, 1), funnelEvent(101, 2)}, 2},
+		{"latest first step", []FunnelEvent{funnelEvent(0, 0), funnelEvent(90, 0), funnelEvent(150, 1), funnelEvent(180, 2)}, 3},
+		{"restart within window", []FunnelEvent{funnelEvent(0, 0), funnelEvent(50, 1), funnelEvent(200, 0), funnelEvent(250, 1), funnelEvent(290, 2)}, 3},
+		{"restart keeps reached steps", []FunnelEvent{funnelEvent(0, 0), funnelEvent(50, 1), funnelEvent(200, 0), funnelEvent(290, 2)}, 2},
+		{"event matching every step", []FunnelEvent{funnelEvent(0, 0, 1, 2)}, 1},
+		{"events matching consecutive steps", []FunnelEvent{funnelEvent(0, 0, 1), funnelEvent(10, 0, 1), funnelEvent(20, 1, 2)}, 3},
+	}
+
+	for _, test := range tests {
+		if reached := funnelReachedSteps(test.events, 3, 100); reached != test.reached {
+			t.Errorf("%s: reached %d steps, want %d", test.name, reached, test.reached)
+		}
+	}
+}
+
+func TestFunnelEventsFunnel(t *testing.T) {
+	steps := []FunnelStep{{Path: "/"}, {Event: "signup"}, {Event: "purchase"}}
+
+	events := FunnelEvents{
+		"a": {funnelEvent(0, 0), funnelEvent(10, 1)},
+		"b": {funnelEvent(0, 0), funnelEvent(10, 1)},
+		"c": {funnelEvent(0, 1)},
+		"e": {funnelEvent(0, 0), funnelEvent(10, 2)},
+	}
+	// Visitors continuing in a later shard
+	events.Merge(FunnelEvents{
+		"a": {funnelEvent(20, 2)},
+		"d": {funnelEvent(30, 0)},
+	})
+
+	funnel := events.Funnel(steps, time.Minute)
+	want := []FunnelStepResult{
+		{Path: "/", Count: 4, ConversionRate: 1},
+		{Event: "signup", Count: 2, ConversionRate: 0.5, DropOffRate: 0.5},
+		{Event: "purchase", Count: 1, ConversionRate: 0.25, DropOffRate: 0.5},
+	}
+
+	if len(funnel.Steps) != len(want) {
+		t.Fatalf("%d steps, want %d", len(funnel.Steps), len(want))
+	}
+	for i, step := range funnel.Steps {
+		if step != want[i] {
+			t.Errorf("step %d: %+v, want %+v", i, step, want[i])
+		}
+	}
+}
+
+func TestFunnelEventsFunnelEmpty(t *testing.T) {
+	funnel := FunnelEvents{}.Funnel([]FunnelStep{{Path: "/"}, {Path: "/b"}}, time.Minute)
+	for i, step := range funnel.Steps {
+		if step.Count != 0 || step.ConversionRate != 0 || step.DropOffRate != 0 {
+			t.Errorf("step %d: %+v, want no visitors", i, step)
+		}
+	}
+}
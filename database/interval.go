@@ -0,0 +1,222 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Calendar intervals supported by time series
+const (
+	IntervalDay     = "day"
+	IntervalWeek    = "week"
+	IntervalMonth   = "month"
+	IntervalQuarter = "quarter"
+	IntervalYear    = "year"
+)
+
+var CalendarIntervals = map[string]bool{
+	IntervalDay:     true,
+	IntervalWeek:    true,
+	IntervalMonth:   true,
+	IntervalQuarter: true,
+	IntervalYear:    true,
+}
+
+// Ways to fill the buckets without analytics of a time series
+const (
+	FillNone = "none"
+	FillZero = "zero"
+	FillNull = "null"
+)
+
+// Maximum number of buckets of a filled time series
+const MaxFilledIntervals = 100000
+
+var ErrTooManyIntervals = errors.New("too many intervals to fill")
+
+// Size of the buckets of a time series
+// Calendar buckets are computed in Location, so that they start at local midnight
+// whatever the offset of Location is at that time (e.g. during DST)
+// Buckets of a number of Seconds are aligned on the Unix epoch
+type SeriesInterval struct {
+	Seconds  int
+	Calendar string
+	Location *time.Location
+}
+
+// Return the location used to compute and print buckets, defaults to UTC
+func (interval SeriesInterval) location() *time.Location {
+	if interval.Location == nil {
+		return time.UTC
+	}
+	return interval.Location
+}
+
+// Return the start of the bucket containing t
+func (interval SeriesInterval) Start(t time.Time) time.Time {
+	t = t.In(interval.location())
+	year, month, day := t.Date()
+
+	switch interval.Calendar {
+	case IntervalDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case IntervalWeek:
+		// Weeks start on monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case IntervalQuarter:
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, t.Location())
+	case IntervalYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+
+	seconds := int64(interval.Seconds)
+	return time.Unix((t.Unix()/seconds)*seconds, 0).In(t.Location())
+}
+
+// Return the start of the bucket following the one starting at start
+func (interval SeriesInterval) Next(start time.Time) time.Time {
+	start = start.In(interval.location())
+	year, month, day := start.Date()
+
+	switch interval.Calendar {
+	case IntervalDay:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, start.Location())
+	case IntervalWeek:
+		return time.Date(year, month, day+7, 0, 0, 0, 0, start.Location())
+	case IntervalMonth:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, start.Location())
+	case IntervalQuarter:
+		return time.Date(year, month+3, 1, 0, 0, 0, 0, start.Location())
+	case IntervalYear:
+		return time.Date(year+1, time.January, 1, 0, 0, 0, 0, start.Location())
+	}
+
+	return start.Add(time.Duration(interval.Seconds) * time.Second)
+}
+
+// Return the start of each bucket overlapping [from, to], in order
+func (interval SeriesInterval) Starts(from time.Time, to time.Time) []time.Time {
+	starts := make([]time.Time, 0)
+	for start := interval.Start(from); !start.After(to); start = interval.Next(start) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// Print a bucket boundary in Location
+func (interval SeriesInterval) Format(t time.Time) string {
+	return t.In(interval.location()).Format(time.RFC3339)
+}
+
+// Sort Intervals by Start
+func (intervals *Intervals) Sort() {
+	byStart := intervalsByStart{
+		list:   intervals.List,
+		starts: make([]time.Time, len(intervals.List)),
+	}
+	for i, interval := range intervals.List {
+		byStart.starts[i], _ = time.Parse(time.RFC3339, interval.Start)
+	}
+
+	sort.Stable(byStart)
+}
+
+// Sort Intervals by their parsed Start
+// Start strings can't be compared as they may have different offsets
+type intervalsByStart struct {
+	list   []Interval
+	starts []time.Time
+}
+
+func (byStart intervalsByStart) Len() int {
+	return len(byStart.list)
+}
+
+func (byStart intervalsByStart) Less(i, j int) bool {
+	return byStart.starts[i].Before(byStart.starts[j])
+}
+
+func (byStart intervalsByStart) Swap(i, j int) {
+	byStart.list[i], byStart.list[j] = byStart.list[j], byStart.list[i]
+	byStart.starts[i], byStart.starts[j] = byStart.starts[j], byStart.starts[i]
+}
+
+// Add the missing buckets between from and to of sorted Intervals, using fill
+// Zero times default to the first or last bucket
+func (intervals *Intervals) Fill(interval SeriesInterval, from time.Time, to time.Time, fill string) error {
+	if fill != FillZero && fill != FillNull {
+		return nil
+	}
+
+	list := intervals.List
+	if from.IsZero() {
+		if len(list) == 0 {
+			return nil
+		}
+		from, _ = time.Parse(time.RFC3339, list[0].Start)
+	}
+	if to.IsZero() {
+		if len(list) == 0 {
+			return nil
+		}
+		to, _ = time.Parse(time.RFC3339, list[len(list)-1].Start)
+	}
+
+	filled := make([]Interval, 0, len(list))
+	next := 0
+	for start := interval.Start(from); !start.After(to); start = interval.Next(start) {
+		if len(filled) >= MaxFilledIntervals {
+			return ErrTooManyIntervals
+		}
+
+		// Keep buckets with analytics
+		matched := false
+		for ; next < len(list); next++ {
+			listStart, _ := time.Parse(time.RFC3339, list[next].Start)
+			if listStart.After(start) {
+				break
+			}
+			filled = append(filled, list[next])
+			matched = matched || listStart.Equal(start)
+		}
+		if matched {
+			continue
+		}
+
+		filled = append(filled, Interval{
+			Start: interval.Format(start),
+			End:   interval.Format(interval.Next(start)),
+			Null:  fill == FillNull,
+		})
+	}
+
+	// Keep buckets after to
+	intervals.List = append(filled, list[next:]...)
+	return nil
+}
+
+// Print Intervals filled with null values as such
+func (interval Interval) MarshalJSON() ([]byte, error) {
+	// Avoid calling MarshalJSON recursively
+	type plainInterval Interval
+	if !interval.Null {
+		return json.Marshal(plainInterval(interval))
+	}
+
+	return json.Marshal(struct {
+		Start    string      `json:"start"`
+		End      string      `json:"end"`
+		Total    *int        `json:"total"`
+		Unique   *int        `json:"unique"`
+		Previous *Comparison `json:"previous,omitempty"`
+	}{
+		Start:    interval.Start,
+		End:      interval.End,
+		Previous: interval.Previous,
+	})
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// Load a location with DST, or skip the test if the time zone database is missing
+func loadLocation(t *testing.T, name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s unavailable: %v", name, err)
+	}
+	return location
+}
+
+func parseTime(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestSeriesIntervalStart(t *testing.T) {
+	paris := loadLocation(t, "Europe/Paris")
+
+	tests := []struct {
+		name     string
+		interval SeriesInterval
+		time     string
+		start    string
+		next     string
+	}{
+		{"seconds", SeriesInterval{Seconds: 3600}, "2016-03-27T10:30:00Z", "2016-03-27T10:00:00Z", "2016-03-27T11:00:00Z"},
+		{"day", SeriesInterval{Calendar: IntervalDay}, "2016-03-27T10:30:00Z", "2016-03-27T00:00:00Z", "2016-03-28T00:00:00Z"},
+		{"day before DST", SeriesInterval{Calendar: IntervalDay, Location: paris}, "2016-03-26T23:30:00Z", "2016-03-27T00:00:00+01:00", "2016-03-28T00:00:00+02:00"},
+		{"day after DST", SeriesInterval{Calendar: IntervalDay, Location: paris}, "2016-10-30T12:00:00Z", "2016-10-30T00:00:00+02:00", "2016-10-31T00:00:00+01:00"},
+		{"week on sunday", SeriesInterval{Calendar: IntervalWeek}, "2016-03-06T12:00:00Z", "2016-02-29T00:00:00Z", "2016-03-07T00:00:00Z"},
+		{"week on monday", SeriesInterval{Calendar: IntervalWeek}, "2016-03-07T00:00:00Z", "2016-03-07T00:00:00Z", "2016-03-14T00:00:00Z"},
+		{"month of leap year", SeriesInterval{Calendar: IntervalMonth}, "2016-02-29T23:59:59Z", "2016-02-01T00:00:00Z", "2016-03-01T00:00:00Z"},
+		{"month in location", SeriesInterval{Calendar: IntervalMonth, Location: paris}, "2016-03-31T22:30:00Z", "2016-04-01T00:00:00+02:00", "2016-05-01T00:00:00+02:00"},
+		{"quarter", SeriesInterval{Calendar: IntervalQuarter}, "2016-12-31T00:00:00Z", "2016-10-01T00:00:00Z", "2017-01-01T00:00:00Z"},
+		{"year", SeriesInterval{Calendar: IntervalYear}, "2016-06-15T00:00:00Z", "2016-01-01T00:00:00Z", "2017-01-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		start := test.interval.Start(parseTime(t, test.time))
+		if got := test.interval.Format(start); got != test.start {
+			t.Errorf("%s: start %s, want %s", test.name, got, test.start)
+		}
+		if got := test.interval.Format(test.interval.Next(start)); got != test.next {
+			t.Errorf("%s: next %s, want %s", test.name, got, test.next)
+		}
+	}
+}
+
+func TestIntervalsFill(t *testing.T) {
+	paris := loadLocation(t, "Europe/Paris")
+	day := SeriesInterval{Calendar: IntervalDay}
+	parisDay := SeriesInterval{Calendar: IntervalDay, Location: paris}
+	hour := SeriesInterval{Seconds: 3600}
+
+	// Bucket with analytics
+	bucket := func(interval SeriesInterval, start string, total int) Interval {
+		startTime := parseTime(t, start)
+		return Interval{
+			Start: interval.Format(startTime),
+			End:   interval.Format(interval.Next(startTime)),
+			Total: total,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		interval SeriesInterval
+		list     []Interval
+		from, to string
+		fill     string
+		starts   []string
+		nulls    []bool
+	}{
+		{
+			name:     "no fill",
+			interval: day,
+			list:     []Interval{bucket(day, "2016-03-02T00:00:00Z", 1)},
+			from:     "2016-03-01T00:00:00Z",
+			to:       "2016-03-03T00:00:00Z",
+			fill:     FillNone,
+			starts:   []string{"2016-03-02T00:00:00Z"},
+			nulls:    []bool{false},
+		},
+		{
+			name:     "zero",
+			interval: day,
+			list:     []Interval{bucket(day, "2016-03-02T00:00:00Z", 1)},
+			from:     "2016-03-01T12:00:00Z",
+			to:       "2016-03-03T12:00:00Z",
+			fill:     FillZero,
+			starts:   []string{"2016-03-01T00:00:00Z", "2016-03-02T00:00:00Z", "2016-03-03T00:00:00Z"},
+			nulls:    []bool{false, false, false},
+		},
+		{
+			name:     "null",
+			interval: hour,
+			list:     []Interval{bucket(hour, "2016-03-01T01:00:00Z", 1)},
+			from:     "2016-03-01T00:00:00Z",
+			to:       "2016-03-01T02:00:00Z",
+			fill:     FillNull,
+			starts:   []string{"2016-03-01T00:00:00Z", "2016-03-01T01:00:00Z", "2016-03-01T02:00:00Z"},
+			nulls:    []bool{true, false, true},
+		},
+		{
+			name:     "DST",
+			interval: parisDay,
+			list:     []Interval{bucket(parisDay, "2016-03-28T00:00:00+02:00", 1)},
+			from:     "2016-03-26T00:00:00+01:00",
+			to:       "2016-03-28T23:00:00+02:00",
+			fill:     FillZero,
+			starts:   []string{"2016-03-26T00:00:00+01:00", "2016-03-27T00:00:00+01:00", "2016-03-28T00:00:00+02:00"},
+			nulls:    []bool{false, false, false},
+		},
+		{
+			name:     "calendar months",
+			interval: SeriesInterval{Calendar: IntervalMonth},
+			list:     nil,
+			from:     "2015-12-15T00:00:00Z",
+			to:       "2016-03-01T00:00:00Z",
+			fill:     FillZero,
+			starts:   []string{"2015-12-01T00:00:00Z", "2016-01-01T00:00:00Z", "2016-02-01T00:00:00Z", "2016-03-01T00:00:00Z"},
+			nulls:    []bool{false, false, false, false},
+		},
+		{
+			name:     "bounds default to buckets",
+			interval: day,
+			list:     []Interval{bucket(day, "2016-03-01T00:00:00Z", 1), bucket(day, "2016-03-04T00:00:00Z", 2)},
+			fill:     FillNull,
+			starts:   []string{"2016-03-01T00:00:00Z", "2016-03-02T00:00:00Z", "2016-03-03T00:00:00Z", "2016-03-04T00:00:00Z"},
+			nulls:    []bool{false, true, true, false},
+		},
+		{
+			name:     "buckets out of range kept",
+			interval: day,
+			list:     []Interval{bucket(day, "2016-02-28T00:00:00Z", 1), bucket(day, "2016-03-05T00:00:00Z", 2)},
+			from:     "2016-03-01T00:00:00Z",
+			to:       "2016-03-02T00:00:00Z",
+			fill:     FillZero,
+			starts:   []string{"2016-02-28T00:00:00Z", "2016-03-01T00:00:00Z", "2016-03-02T00:00:00Z", "2016-03-05T00:00:00Z"},
+			nulls:    []bool{false, false, false, false},
+		},
+		{
+			name:     "empty without bounds",
+			interval: day,
+			fill:     FillZero,
+			starts:   []string{},
+			nulls:    []bool{},
+		},
+	}
+
+	for _, test := range tests {
+		var from, to time.Time
+		if test.from != "" {
+			from = parseTime(t, test.from)
+		}
+		if test.to != "" {
+			to = parseTime(t, test.to)
+		}
+
+		intervals := Intervals{List: test.list}
+		if err := intervals.Fill(test.interval, from, to, test.fill); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(intervals.List) != len(test.starts) {
+			t.Errorf("%s: %d intervals, want %d", test.name, len(intervals.List), len(test.starts))
+			continue
+		}
+		for i, interval := range intervals.List {
+			if interval.Start != test.starts[i] || interval.Null != test.nulls[i] {
+				t.Errorf("%s: interval %d starts %s null %v, want %s %v", test.name, i, interval.Start, interval.Null, test.starts[i], test.nulls[i])
+			}
+			if interval.End != test.interval.Format(test.interval.Next(parseTime(t, interval.Start))) {
+				t.Errorf("%s: interval %d ends %s", test.name, i, interval.End)
+			}
+		}
+	}
+}
+
+func TestIntervalsFillTooMany(t *testing.T) {
+	intervals := Intervals{}
+	from := parseTime(t, "2016-01-01T00:00:00Z")
+	to := from.Add(time.Duration(MaxFilledIntervals) * time.Second)
+
+	err := intervals.Fill(SeriesInterval{Seconds: 1}, from, to, FillZero)
+	if err != ErrTooManyIntervals {
+		t.Errorf("error %v, want %v", err, ErrTooManyIntervals)
+	}
+}
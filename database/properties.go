@@ -0,0 +1,25 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Prefix of custom properties, set in the properties object of analytics
+const CustomPropertyPrefix = "properties."
+
+// Keys of custom properties are restricted to be safely used in JSON paths
+var customPropertyKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Return the key of a custom property, e.g. plan for properties.plan
+func CustomPropertyKey(property string) (string, bool) {
+	if !strings.HasPrefix(property, CustomPropertyPrefix) {
+		return "", false
+	}
+
+	key := strings.TrimPrefix(property, CustomPropertyPrefix)
+	if !customPropertyKeyRegexp.MatchString(key) {
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// Default inactivity after which the next analytic of a visitor starts a new session
+const DefaultSessionTimeout = 30 * time.Minute
+
+// Number of entry and exit pages returned by default
+const DefaultSessionPagesLimit = 10
+
+// Metrics of the sessions reconstructed from analytics
+// Durations are in seconds and BounceRate is between 0 and 1
+type Sessions struct {
+	Count           int           `json:"count"`
+	AverageDuration float64       `json:"averageDuration"`
+	PagesPerSession float64       `json:"pagesPerSession"`
+	BounceRate      float64       `json:"bounceRate"`
+	Entries         []SessionPage `json:"entries"`
+	Exits           []SessionPage `json:"exits"`
+}
+
+// Number of sessions starting or ending on a path
+type SessionPage struct {
+	Path     string `json:"path"`
+	Sessions int    `json:"sessions"`
+}
+
+// A session from its first and last analytics, times being Unix timestamps
+type SessionSpan struct {
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Entry string `json:"entry"`
+	Exit  string `json:"exit"`
+	Pages int    `json:"pages"`
+}
+
+// Sessions of a visitor which may continue in the previous or next shard
+// Last is nil if the visitor has a single session in the shard
+type VisitorSessions struct {
+	First SessionSpan  `json:"first"`
+	Last  *SessionSpan `json:"last,omitempty"`
+}
+
+// Sums over the sessions of a shard, which can be merged with other shards
+// The first and last sessions of each visitor are kept apart in Visitors
+// until they are joined with the sessions of adjacent shards
+// Bounces are sessions of a single analytic
+type SessionStats struct {
+	Count    int                        `json:"count"`
+	Duration int64                      `json:"duration"`
+	Pages    int                        `json:"pages"`
+	Bounces  int                        `json:"bounces"`
+	Entries  map[string]int             `json:"entries"`
+	Exits    map[string]int             `json:"exits"`
+	Visitors map[string]VisitorSessions `json:"visitors"`
+}
+
+// Return empty SessionStats
+func NewSessionStats() *SessionStats {
+	return &SessionStats{
+		Entries:  make(map[string]int),
+		Exits:    make(map[string]int),
+		Visitors: make(map[string]VisitorSessions),
+	}
+}
+
+// Add a complete session
+func (stats *SessionStats) Add(session SessionSpan) {
+	stats.Count++
+	stats.Duration += session.End - session.Start
+	stats.Pages += session.Pages
+	if session.Pages == 1 {
+		stats.Bounces++
+	}
+	stats.Entries[session.Entry]++
+	stats.Exits[session.Exit]++
+}
+
+// Add the sessions of a visitor in a shard, in time order
+// Sessions in between the first and last ones are complete
+func (stats *SessionStats) AddVisitor(visitor string, sessions []SessionSpan) {
+	if len(sessions) == 0 {
+		return
+	}
+
+	visitorSessions := VisitorSessions{First: sessions[0]}
+	if len(sessions) > 1 {
+		last := sessions[len(sessions)-1]
+		visitorSessions.Last = &last
+		for _, session := range sessions[1 : len(sessions)-1] {
+			stats.Add(session)
+		}
+	}
+	stats.Visitors[visitor] = visitorSessions
+}
+
+// Add the sessions of the next shard
+// The last session of a visitor is joined with their first session in the next
+// shard if they are not inactive for longer than timeout in between
+func (stats *SessionStats) Merge(other SessionStats, timeout time.Duration) {
+	stats.Count += other.Count
+	stats.Duration += other.Duration
+	stats.Pages += other.Pages
+	stats.Bounces += other.Bounces
+	for path, count := range other.Entries {
+		stats.Entries[path] += count
+	}
+	for path, count := range other.Exits {
+		stats.Exits[path] += count
+	}
+
+	for visitor, next := range other.Visitors {
+		first := next.First
+		if previous, ok := stats.Visitors[visitor]; ok {
+			last := previous.First
+			if previous.Last != nil {
+				stats.Add(previous.First)
+				last = *previous.Last
+			}
+
+			if first.Start-last.End <= int64(timeout.Seconds()) {
+				first = SessionSpan{
+					Start: last.Start,
+					End:   first.End,
+					Entry: last.Entry,
+					Exit:  first.Exit,
+					Pages: last.Pages + first.Pages,
+				}
+			} else {
+				stats.Add(last)
+			}
+		}
+
+		// Only the last session of a visitor can continue in a later shard
+		if next.Last != nil {
+			stats.Add(first)
+			first = *next.Last
+		}
+		stats.Visitors[visitor] = VisitorSessions{First: first}
+	}
+}
+
+// Compute the metrics of the sessions, with at most limit entry and exit pages
+// A limit of 0 keeps every page
+// Sessions of Visitors are considered complete and added first
+func (stats *SessionStats) Sessions(limit int) *Sessions {
+	for visitor, visitorSessions := range stats.Visitors {
+		stats.Add(visitorSessions.First)
+		if visitorSessions.Last != nil {
+			stats.Add(*visitorSessions.Last)
+		}
+		delete(stats.Visitors, visitor)
+	}
+
+	sessions := Sessions{
+		Count:   stats.Count,
+		Entries: sessionPages(stats.Entries, limit),
+		Exits:   sessionPages(stats.Exits, limit),
+	}
+
+	if stats.Count > 0 {
+		sessions.AverageDuration = float64(stats.Duration) / float64(stats.Count)
+		sessions.PagesPerSession = float64(stats.Pages) / float64(stats.Count)
+		sessions.BounceRate = float64(stats.Bounces) / float64(stats.Count)
+	}
+
+	return &sessions
+}
+
+// Define an alias of []SessionPage ordered by Sessions descending, then by Path
+type SessionPageList []SessionPage
+
+func (l SessionPageList) Len() int {
+	return len(l)
+}
+func (l SessionPageList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+func (l SessionPageList) Less(i, j int) bool {
+	if l[i].Sessions != l[j].Sessions {
+		return l[i].Sessions > l[j].Sessions
+	}
+	return l[i].Path < l[j].Path
+}
+
+// Rank paths by number of sessions
+func sessionPages(counts map[string]int, limit int) []SessionPage {
+	list := make(SessionPageList, 0, len(counts))
+	for path, count := range counts {
+		list = append(list, SessionPage{Path: path, Sessions: count})
+	}
+	sort.Sort(list)
+
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// Session of a visitor from start to end with a page per path
+func span(start int64, end int64, paths ...string) SessionSpan {
+	return SessionSpan{
+		Start: start,
+		End:   end,
+		Entry: paths[0],
+		Exit:  paths[len(paths)-1],
+		Pages: len(paths),
+	}
+}
+
+func TestSessionStatsMerge(t *testing.T) {
+	timeout := 30 * time.Minute
+
+	tests := []struct {
+		name     string
+		shards   [][]SessionSpan
+		count    int
+		duration int64
+		pages    int
+		bounces  int
+		entries  map[string]int
+		exits    map[string]int
+	}{
+		{
+			name:     "single shard",
+			shards:   [][]SessionSpan{{span(0, 60, "/a", "/b"), span(4000, 4000, "/c")}},
+			count:    2,
+			duration: 60,
+			pages:    3,
+			bounces:  1,
+			entries:  map[string]int{"/a": 1, "/c": 1},
+			exits:    map[string]int{"/b": 1, "/c": 1},
+		},
+		{
+			name:     "joined across shards",
+			shards:   [][]SessionSpan{{span(0, 60, "/a", "/b")}, {span(1800+60, 1900, "/c")}},
+			count:    1,
+			duration: 1900,
+			pages:    3,
+			entries:  map[string]int{"/a": 1},
+			exits:    map[string]int{"/c": 1},
+		},
+		{
+			name:     "split after timeout",
+			shards:   [][]SessionSpan{{span(0, 60, "/a", "/b")}, {span(1800+61, 1900, "/c")}},
+			count:    2,
+			duration: 60 + 39,
+			pages:    3,
+			bounces:  1,
+			entries:  map[string]int{"/a": 1, "/c": 1},
+			exits:    map[string]int{"/b": 1, "/c": 1},
+		},
+		{
+			name: "last session joined with first of next shard",
+			shards: [][]SessionSpan{
+				{span(0, 0, "/a"), span(5000, 5100, "/b", "/c")},
+				{span(5200, 5300, "/d"), span(9000, 9000, "/e")},
+			},
+			count:    3,
+			duration: 300,
+			pages:    5,
+			bounces:  2,
+			entries:  map[string]int{"/a": 1, "/b": 1, "/e": 1},
+			exits:    map[string]int{"/a": 1, "/d": 1, "/e": 1},
+		},
+		{
+			name: "session spanning three shards",
+			shards: [][]SessionSpan{
+				{span(0, 100, "/a", "/b")},
+				{span(200, 300, "/c")},
+				{span(400, 500, "/d", "/e")},
+			},
+			count:    1,
+			duration: 500,
+			pages:    5,
+			entries:  map[string]int{"/a": 1},
+			exits:    map[string]int{"/e": 1},
+		},
+		{
+			name:     "visitor absent from a shard",
+			shards:   [][]SessionSpan{{span(0, 100, "/a")}, nil, {span(200, 300, "/b")}},
+			count:    1,
+			duration: 300,
+			pages:    2,
+			entries:  map[string]int{"/a": 1},
+			exits:    map[string]int{"/b": 1},
+		},
+		{
+			name:    "no sessions",
+			shards:  [][]SessionSpan{nil, nil},
+			entries: map[string]int{},
+			exits:   map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		stats := NewSessionStats()
+		for _, sessions := range test.shards {
+			shard := NewSessionStats()
+			shard.AddVisitor("visitor", sessions)
+			stats.Merge(*shard, timeout)
+		}
+		stats.Sessions(0)
+
+		if stats.Count != test.count || stats.Duration != test.duration || stats.Pages != test.pages || stats.Bounces != test.bounces {
+			t.Errorf("%s: count %d duration %d pages %d bounces %d, want %d %d %d %d", test.name,
+				stats.Count, stats.Duration, stats.Pages, stats.Bounces,
+				test.count, test.duration, test.pages, test.bounces)
+		}
+		if !equalCounts(stats.Entries, test.entries) {
+			t.Errorf("%s: entries %v, want %v", test.name, stats.Entries, test.entries)
+		}
+		if !equalCounts(stats.Exits, test.exits) {
+			t.Errorf("%s: exits %v, want %v", test.name, stats.Exits, test.exits)
+		}
+	}
+}
+
+func TestSessionStatsMergeVisitors(t *testing.T) {
+	previous := NewSessionStats()
+	previous.AddVisitor("a", []SessionSpan{span(0, 100, "/a")})
+	previous.AddVisitor("b", []SessionSpan{span(0, 100, "/b")})
+
+	next := NewSessionStats()
+	next.AddVisitor("b", []SessionSpan{span(200, 300, "/b")})
+	next.AddVisitor("c", []SessionSpan{span(200, 300, "/c")})
+
+	previous.Merge(*next, time.Minute)
+	sessions := previous.Sessions(0)
+
+	// a and c have a session each, b's session is split by the timeout
+	if sessions.Count != 4 {
+		t.Errorf("count %d, want 4", sessions.Count)
+	}
+	if len(previous.Visitors) != 0 {
+		t.Errorf("%d visitors left", len(previous.Visitors))
+	}
+}
+
+func TestSessions(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		sessions []SessionSpan
+		want     Sessions
+	}{
+		{
+			name:     "empty",
+			sessions: nil,
+			want:     Sessions{Entries: []SessionPage{}, Exits: []SessionPage{}},
+		},
+		{
+			name:     "metrics",
+			sessions: []SessionSpan{span(0, 100, "/a", "/b", "/c"), span(200, 200, "/b"), span(300, 320, "/a", "/b"), span(400, 400, "/a")},
+			want: Sessions{
+				Count:           4,
+				AverageDuration: 30,
+				PagesPerSession: 7.0 / 4,
+				BounceRate:      0.5,
+				Entries:         []SessionPage{{"/a", 3}, {"/b", 1}},
+				Exits:           []SessionPage{{"/b", 2}, {"/a", 1}, {"/c", 1}},
+			},
+		},
+		{
+			name:     "limited pages",
+			limit:    1,
+			sessions: []SessionSpan{span(0, 0, "/b"), span(100, 100, "/a"), span(200, 200, "/a")},
+			want: Sessions{
+				Count:           3,
+				PagesPerSession: 1,
+				BounceRate:      1,
+				Entries:         []SessionPage{{"/a", 2}},
+				Exits:           []SessionPage{{"/a", 2}},
+			},
+		},
+		{
+			name:     "pages with as many sessions ordered by path",
+			sessions: []SessionSpan{span(0, 0, "/b"), span(100, 100, "/a")},
+			want: Sessions{
+				Count:           2,
+				PagesPerSession: 1,
+				BounceRate:      1,
+				Entries:         []SessionPage{{"/a", 1}, {"/b", 1}},
+				Exits:           []SessionPage{{"/a", 1}, {"/b", 1}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		stats := NewSessionStats()
+		for _, session := range test.sessions {
+			stats.Add(session)
+		}
+		got := stats.Sessions(test.limit)
+		want := test.want
+		if got.Count != want.Count || got.AverageDuration != want.AverageDuration ||
+			got.PagesPerSession != want.PagesPerSession || got.BounceRate != want.BounceRate {
+			t.Errorf("%s: %+v, want %+v", test.name, *got, want)
+		}
+		if !equalPages(got.Entries, want.Entries) || !equalPages(got.Exits, want.Exits) {
+			t.Errorf("%s: entries %v exits %v, want %v %v", test.name, got.Entries, got.Exits, want.Entries, want.Exits)
+		}
+	}
+}
+
+func equalCounts(a map[string]int, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPages(a []SessionPage, b []SessionPage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/GitbookIO/go-sqlpool"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/errors"
+
+	"github.com/GitbookIO/micro-analytics/database/sqlite/manager"
+	"github.com/GitbookIO/micro-analytics/database/sqlite/query"
+)
+
+// Analytics of a bulk insert going to the same DB file
+// Positions are the indexes of analytics in the list of their DB
+type fileInsert struct {
+	DBName    string
+	Path      manager.DBPath
+	Analytics []database.Analytic
+	Positions []int
+}
+
+// Insert analytics file by file, each file in its own transaction
+// A file failing doesn't prevent inserting others, failed analytics are listed
+// by their position in a returned *errors.BulkInsertError
+func bulkInsertFiles(ctx context.Context, dbManager *manager.DBManager, inserts []fileInsert) error {
+	var acquireErr, insertErr error
+	bulkErr := &errors.BulkInsertError{}
+	for _, insert := range inserts {
+		// Get DB from manager
+		db, err := dbManager.Acquire(insert.Path)
+		if err != nil {
+			dbManager.Logger.Error("Error executing Insert/Acquire on DB %s: %v\n", insert.Path, err)
+			acquireErr = err
+			bulkErr.Fail(insert.DBName, insert.Positions)
+			continue
+		}
+
+		// Insert data if everything's OK
+		err = query.BulkInsert(ctx, db.DB, insert.Analytics)
+		dbManager.Release(db)
+		if err != nil {
+			dbManager.Logger.Error("Error executing Insert on DB %s: %v\n", insert.Path, err)
+			insertErr = err
+			bulkErr.Fail(insert.DBName, insert.Positions)
+		}
+	}
+
+	if insertErr != nil {
+		bulkErr.DriverError = errors.InsertFailed
+		return bulkErr
+	}
+	if acquireErr != nil {
+		bulkErr.DriverError = errors.InternalError
+		return bulkErr
+	}
+
+	return nil
+}
+
+// Insert analytics in all files or in none of them
+// Transactions of every file are only committed once all inserts succeeded
+// A file failing to commit after others did is the only case of partial insert
+func bulkInsertFilesAtomic(ctx context.Context, dbManager *manager.DBManager, inserts []fileInsert) error {
+	// Always open transactions in the same order
+	sort.Sort(fileInsertsByPath(inserts))
+
+	dbs := make([]*sqlpool.Resource, 0, len(inserts))
+	defer func() {
+		for _, db := range dbs {
+			dbManager.Release(db)
+		}
+	}()
+
+	txs := make([]*sql.Tx, 0, len(inserts))
+	var failErr *errors.DriverError
+	for _, insert := range inserts {
+		// Get DB from manager
+		db, err := dbManager.Acquire(insert.Path)
+		if err != nil {
+			dbManager.Logger.Error("Error executing Insert/Acquire on DB %s: %v\n", insert.Path, err)
+			failErr = &errors.InternalError
+			break
+		}
+		dbs = append(dbs, db)
+
+		// Insert data without committing it
+		tx, err := query.BeginBulkInsert(ctx, db.DB, insert.Analytics)
+		if err != nil {
+			dbManager.Logger.Error("Error executing Insert on DB %s: %v\n", insert.Path, err)
+			failErr = &errors.InsertFailed
+			break
+		}
+		txs = append(txs, tx)
+	}
+
+	// Roll back every insert and fail all analytics if one failed
+	if failErr != nil {
+		for _, tx := range txs {
+			tx.Rollback()
+		}
+
+		bulkErr := &errors.BulkInsertError{
+			DriverError: *failErr,
+		}
+		for _, insert := range inserts {
+			bulkErr.Fail(insert.DBName, insert.Positions)
+		}
+		return bulkErr
+	}
+
+	// Commit every insert
+	bulkErr := &errors.BulkInsertError{}
+	for i, tx := range txs {
+		if err := tx.Commit(); err != nil {
+			dbManager.Logger.Error("Error executing Insert/Commit on DB %s: %v\n", inserts[i].Path, err)
+			bulkErr.Fail(inserts[i].DBName, inserts[i].Positions)
+		}
+	}
+
+	if len(bulkErr.Failed) > 0 {
+		bulkErr.DriverError = errors.InsertFailed
+		return bulkErr
+	}
+
+	return nil
+}
+
+// Sort inserts by DB file path
+type fileInsertsByPath []fileInsert
+
+func (f fileInsertsByPath) Len() int           { return len(f) }
+func (f fileInsertsByPath) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f fileInsertsByPath) Less(i, j int) bool { return f[i].Path.String() < f[j].Path.String() }
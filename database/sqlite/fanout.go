@@ -0,0 +1,198 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/errors"
+
+	"github.com/GitbookIO/micro-analytics/database/sqlite/manager"
+)
+
+// Default number of shards queried concurrently by a request
+const defaultQueryWorkers = 4
+
+// A monthly shard of a DB
+type shard struct {
+	Name string
+	Int  int
+	Path manager.DBPath
+}
+
+// Return the shards of a DB in params.TimeRange, in chronological order
+// method is only used for logging
+func (driver *Sharded) shardsInRange(params database.Params, method string) ([]shard, error) {
+	// Construct DBPath
+	dbPath := manager.DBPath{
+		Name:      params.DBName,
+		Directory: driver.directory,
+	}
+
+	// Check if DB file exists
+	dbExists, err := driver.DBManager.DBExists(dbPath)
+	if err != nil {
+		driver.DBManager.Logger.Error("Error executing %s/DBExists on DB %s: %v\n", method, dbPath, err)
+		return nil, &errors.InternalError
+	}
+
+	// DB doesn't exist
+	if !dbExists {
+		return nil, &errors.InvalidDatabaseName
+	}
+
+	// At this point, there should be shards to query
+	// Get list of shards by reading directory
+	startInt, endInt := timeRangeToInt(params.TimeRange)
+
+	shards := make([]shard, 0)
+	for _, shardName := range listShards(dbPath) {
+		// Don't include shard if not in timerange
+		shardInt, err := shardNameToInt(shardName)
+		if err != nil {
+			return nil, err
+		}
+
+		if shardInt < startInt || shardInt > endInt {
+			continue
+		}
+
+		shards = append(shards, shard{
+			Name: shardName,
+			Int:  shardInt,
+			Path: manager.DBPath{
+				Name:      shardName,
+				Directory: dbPath.String(),
+			},
+		})
+	}
+
+	return shards, nil
+}
+
+// Run queryShard on every shard, with at most driver.queryWorkers shards queried at a time
+// queryShard receives the index of the shard so that results can be merged in order
+// The first error, or ctx being done, prevents the remaining shards from being queried
+func (driver *Sharded) fanOut(ctx context.Context, shards []shard, queryShard func(i int, s shard) error) error {
+	workers := driver.queryWorkers
+	if workers > len(shards) {
+		workers = len(shards)
+	}
+
+	jobs := make(chan int)
+	abort := make(chan struct{})
+
+	var firstErr error
+	var abortOnce sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Skip remaining jobs once aborted
+				select {
+				case <-abort:
+					continue
+				case <-ctx.Done():
+					continue
+				default:
+				}
+
+				if err := queryShard(i, shards[i]); err != nil {
+					abortOnce.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+				}
+			}
+		}()
+	}
+
+	// Feed shards to workers until done or aborted
+feed:
+	for i := range shards {
+		select {
+		case jobs <- i:
+		case <-abort:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	// Some shards were skipped if ctx is done
+	if firstErr == nil && ctx.Err() != nil {
+		return queryError(ctx)
+	}
+	return firstErr
+}
+
+// Get the result of a shard query from cache
+// or run runQuery on the shard and set its result in cache if asked
+// result must point to the value set by runQuery
+func (driver *Sharded) cachedShardQuery(ctx context.Context, params database.Params, s shard, method string, result interface{}, runQuery func(db *sql.DB) error) error {
+	startInt, endInt := timeRangeToInt(params.TimeRange)
+
+	// Get result if is cached
+	cacheURL, err := formatURLForCache(params.URL, s.Int, startInt, endInt, params.TimeRange, params.Filters)
+	if err != nil {
+		return err
+	}
+
+	cached, inCache := driver.cache.Get(cacheURL)
+	if inCache {
+		err = json.Unmarshal(cached, result)
+		if err != nil {
+			driver.DBManager.Logger.Error("Error unmarshaling from cache: %v\n", err)
+			return err
+		}
+		return nil
+	}
+
+	// Else query shard
+	// Get DB shard from manager
+	db, err := driver.DBManager.Acquire(s.Path)
+	if err != nil {
+		driver.DBManager.Logger.Error("Error executing %s/Acquire on DB %s: %v\n", method, s.Path, err)
+		return &errors.InternalError
+	}
+	defer driver.DBManager.Release(db)
+
+	err = runQuery(db.DB)
+	if err != nil {
+		driver.DBManager.Logger.Error("Error executing %s on DB %s: %v\n", method, s.Path, err)
+		return queryError(ctx)
+	}
+
+	// Set shard result in cache if asked
+	if cachedRequest(params.URL) {
+		if data, err := json.Marshal(result); err == nil {
+			err = driver.cache.Set(cacheURL, data)
+			if err != nil {
+				driver.DBManager.Logger.Error("Error adding to cache: %v\n", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the driver error matching a failed query
+// Queries failing because ctx is done are reported as timed out or canceled
+func queryError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return &errors.QueryTimeout
+	case context.Canceled:
+		return &errors.QueryCanceled
+	default:
+		return &errors.InternalError
+	}
+}
@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// Shards smaller than this are fast enough to query without statistics
+const analyzeMinRows = 1000
+
+// Gather statistics of the query planner if missing, or if the visits table
+// doubled in size since they were gathered
+// Without them SQLite picks the time index even for ranges covering
+// most of a shard, which is slower than a full scan
+func AnalyzeIfStale(db *sql.DB) error {
+	// Analytics are never updated, so the last rowid is close to the row count
+	var rows int64
+	if err := db.QueryRow(`SELECT COALESCE(MAX(rowid), 0) FROM visits`).Scan(&rows); err != nil {
+		return err
+	}
+	if rows < analyzeMinRows {
+		return nil
+	}
+
+	analyzedRows, err := analyzedRowCount(db)
+	if err != nil {
+		return err
+	}
+	if rows <= 2*analyzedRows {
+		return nil
+	}
+
+	_, err = db.Exec(`ANALYZE`)
+	return err
+}
+
+// Return the row count of the visits table when it was last analyzed
+// 0 is returned if it was never analyzed
+func analyzedRowCount(db *sql.DB) (int64, error) {
+	analyzed, err := tableExists(db, "sqlite_stat1")
+	if err != nil || !analyzed {
+		return 0, err
+	}
+
+	// Statistics of an index start with the number of rows of its table
+	var stat sql.NullString
+	err = db.QueryRow(`SELECT stat FROM sqlite_stat1 WHERE tbl='visits' AND idx='visits_time'`).Scan(&stat)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := strconv.ParseInt(strings.Fields(stat.String + " 0")[0], 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+	return rows, nil
+}
@@ -7,7 +7,8 @@ import (
 	"time"
 )
 
-const dbFileName = "analytics.db"
+// File name of databases in their directory
+const DBFileName = "analytics.db"
 
 type Database struct {
 	sync.Mutex
@@ -28,7 +29,7 @@ func (db *Database) Name() string {
 
 // Print DBPath with filename
 func (dbPath *DBPath) FileName() string {
-	return path.Join(dbPath.Directory, dbPath.Name, dbFileName)
+	return path.Join(dbPath.Directory, dbPath.Name, DBFileName)
 }
 
 // Print DBPath directory
@@ -2,8 +2,10 @@ package manager
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/GitbookIO/go-sqlpool"
@@ -54,7 +56,15 @@ func New(opts Opts) *DBManager {
 
 // Acquire a DB connection
 func (manager *DBManager) Acquire(dbPath DBPath) (*sqlpool.Resource, error) {
-	return manager.Pool.Acquire("sqlite3", dbPath.FileName())
+	return manager.Pool.Acquire("sqlite3", DataSourceName(dbPath.FileName()))
+}
+
+// Return the data source name opening a DB file
+// Transactions take the write lock when they begin, so that concurrent
+// migrations and inserts wait for each other instead of failing to upgrade
+// their read lock
+func DataSourceName(file string) string {
+	return file + "?_txlock=immediate"
 }
 
 // Release a DB connection
@@ -74,6 +84,7 @@ func (manager *DBManager) DeleteDB(dbPath DBPath) error {
 
 // PreInit function for sqlPool
 func createDirectory(driver, url string) error {
+	url = strings.SplitN(url, "?", 2)[0]
 	dbExists, err := utils.PathExists(url)
 	if err != nil {
 		return err
@@ -87,42 +98,21 @@ func createDirectory(driver, url string) error {
 }
 
 // PostInit function for sqlPool
+// New shards are created with the latest schema, existing ones are migrated
+// before serving requests, at startup or by the migrate command
 func initializeDatabase(db *sql.DB) error {
-	const dbSchema = `
-    CREATE TABLE visits (
-        time            INTEGER,
-        event           TEXT,
-        path            TEXT,
-        ip              TEXT,
-        platform        TEXT,
-        refererDomain   TEXT,
-        countryCode     TEXT
-    )`
-
-	tableExists, err := tableExists(db)
-	if err != nil {
+	version, err := SchemaVersion(db)
+	if err != nil || version == LatestSchemaVersion() {
 		return err
 	}
 
-	if !tableExists {
-		_, err = db.Exec(dbSchema)
-	}
-
-	return err
-}
-
-// Check wether the visits table already exists
-func tableExists(db *sql.DB) (bool, error) {
-	// Query
-	existsQuery := `SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='visits'`
-
-	// Execute query
-	var count int
-	err := db.QueryRow(existsQuery).Scan(&count)
-
+	created, err := tableExists(db, "visits")
 	if err != nil {
-		return false, err
+		return err
+	}
+	if version == 0 && !created {
+		return Migrate(db)
 	}
 
-	return count == 1, nil
+	return fmt.Errorf("schema version %d is not the latest %d, shard must be migrated", version, LatestSchemaVersion())
 }
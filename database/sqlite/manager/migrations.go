@@ -0,0 +1,212 @@
+package manager
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A versioned change of the shard schema
+// The version of a shard is stored in its PRAGMA user_version
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// Migrations applied to every shard, in version order
+// Applied migrations must never be changed, add a new one instead
+var Migrations = []Migration{
+	{1, "Create visits table", createVisitsTable},
+	{2, "Add id column and its unique index", addIdColumn},
+	{3, "Add properties column", addPropertiesColumn},
+	{4, "Add indexes on time and grouped columns", addIndexes},
+	{5, "Add userId and sessionId columns", addVisitorColumns},
+}
+
+// Version of shards with every migration applied
+func LatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Read the schema version of a shard, 0 for shards created before migrations
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// Return the migrations not applied yet to a shard
+// Shards migrated by a more recent version of the service can't be used
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > LatestSchemaVersion() {
+		return nil, fmt.Errorf("schema version %d is more recent than %d", version, LatestSchemaVersion())
+	}
+
+	pending := make([]Migration, 0)
+	for _, migration := range Migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Apply pending migrations to a shard, each one in its own transaction
+func Migrate(db *sql.DB) error {
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if err := applyMigration(db, migration); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+	}
+
+	return nil
+}
+
+// Run a migration and set the shard version in the same transaction
+// DB must be opened with DataSourceName so that the transaction holds
+// the write lock from its start
+func applyMigration(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Another connection may have applied it in the meantime
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if version >= migration.Version {
+		return tx.Rollback()
+	}
+
+	if err := migration.Up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// PRAGMA statements don't accept bound parameters
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", migration.Version)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Shards created before migrations already have the table
+func createVisitsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS visits (
+        time            INTEGER,
+        event           TEXT,
+        path            TEXT,
+        ip              TEXT,
+        platform        TEXT,
+        refererDomain   TEXT,
+        countryCode     TEXT
+    )`)
+	return err
+}
+
+// Ignore analytics inserted twice with the same id
+func addIdColumn(tx *sql.Tx) error {
+	if err := addColumn(tx, "id", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS visits_id ON visits (id)`)
+	return err
+}
+
+// Custom properties stored as a JSON object
+func addPropertiesColumn(tx *sql.Tx) error {
+	return addColumn(tx, "properties", "TEXT")
+}
+
+// Index time ranges, and grouped columns along with time and ip
+// so that aggregations and unique counts are read from the indexes only
+// Statistics are gathered for the query planner to choose between them
+func addIndexes(tx *sql.Tx) error {
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS visits_time ON visits (time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_event ON visits (event, time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_path ON visits (path, time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_platform ON visits (platform, time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_refererDomain ON visits (refererDomain, time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_countryCode ON visits (countryCode, time, ip)`,
+		`CREATE INDEX IF NOT EXISTS visits_ip ON visits (ip, time)`,
+	}
+
+	for _, index := range indexes {
+		if _, err := tx.Exec(index); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`ANALYZE`)
+	return err
+}
+
+// Identify visitors beyond their IP, to count unique users and sessions
+func addVisitorColumns(tx *sql.Tx) error {
+	if err := addColumn(tx, "userId", "TEXT"); err != nil {
+		return err
+	}
+	return addColumn(tx, "sessionId", "TEXT")
+}
+
+// Add a column to the visits table
+// Shards created before migrations may already have it
+func addColumn(tx *sql.Tx, column string, columnType string) error {
+	exists, err := columnExists(tx, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE visits ADD COLUMN %s %s", column, columnType))
+	return err
+}
+
+// Check whether a table exists
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&count)
+	return count > 0, err
+}
+
+// Check whether the visits table has a column
+func columnExists(tx *sql.Tx, column string) (bool, error) {
+	rows, err := tx.Query(`PRAGMA table_info(visits)`)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+
+	return exists, rows.Err()
+}
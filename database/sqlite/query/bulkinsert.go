@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 
 	sq "github.com/Masterminds/squirrel"
@@ -8,32 +9,116 @@ import (
 	"github.com/GitbookIO/micro-analytics/database"
 )
 
-// Wrapper for inserting through a Database struct
-func BulkInsert(db *sql.DB, analytics []database.Analytic) error {
-	// Base query
-	insertQuery := sq.
-		Insert("visits").
-		Columns("time", "event", "path", "ip", "platform", "refererDomain", "countryCode")
-
-	// Add values for each analytic object
-	for _, analytic := range analytics {
-		insertQuery = insertQuery.Values(
-			analytic.Time.Unix(),
-			analytic.Event,
-			analytic.Path,
-			analytic.Ip,
-			analytic.Platform,
-			analytic.RefererDomain,
-			analytic.CountryCode)
-	}
+// Maximum number of variables of a SQLite statement
+const sqliteMaxVariables = 999
+
+// Columns set when inserting an analytic, in order
+var insertColumns = []string{"time", "event", "path", "ip", "platform", "refererDomain", "countryCode", "id", "properties", "userId", "sessionId"}
 
-	// Add database
-	insertQuery = insertQuery.RunWith(db)
+// Number of analytics inserted by a single statement
+// Keeps statements under SQLite's limit of variables whatever the number of columns
+var bulkInsertChunkSize = sqliteMaxVariables / len(insertColumns)
 
-	_, err := insertQuery.Exec()
+// Insert analytics in a single transaction
+// Analytics with the id of an already inserted one are ignored
+func BulkInsert(ctx context.Context, db *sql.DB, analytics []database.Analytic) error {
+	tx, err := BeginBulkInsert(ctx, db, analytics)
 	if err != nil {
 		return err
 	}
 
+	return tx.Commit()
+}
+
+// Insert analytics in a new transaction and return it uncommitted,
+// letting the caller decide to commit or roll back the insert
+// The transaction is rolled back if an error is returned
+func BeginBulkInsert(ctx context.Context, db *sql.DB, analytics []database.Analytic) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bulkInsert(ctx, tx, analytics); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Insert analytics by chunks, preparing the statement of full chunks once
+func bulkInsert(ctx context.Context, tx *sql.Tx, analytics []database.Analytic) error {
+	var stmt *sql.Stmt
+	for start := 0; start < len(analytics); start += bulkInsertChunkSize {
+		end := start + bulkInsertChunkSize
+		if end > len(analytics) {
+			end = len(analytics)
+		}
+		chunk := analytics[start:end]
+
+		// Values of the chunk, in order
+		args := make([]interface{}, 0, len(chunk)*len(insertColumns))
+		for _, analytic := range chunk {
+			args = append(args,
+				analytic.Time.Unix(),
+				analytic.Event,
+				analytic.Path,
+				analytic.Ip,
+				analytic.Platform,
+				analytic.RefererDomain,
+				analytic.CountryCode,
+				nullableId(analytic.Id),
+				nullableProperties(analytic.Properties),
+				analytic.UserId,
+				analytic.SessionId)
+		}
+
+		// Last chunk may be smaller than others
+		if len(chunk) < bulkInsertChunkSize {
+			query, err := bulkInsertQuery(len(chunk))
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+			break
+		}
+
+		if stmt == nil {
+			query, err := bulkInsertQuery(bulkInsertChunkSize)
+			if err != nil {
+				return err
+			}
+			stmt, err = tx.PrepareContext(ctx, query)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+		}
+
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// Return a statement inserting rows analytics
+func bulkInsertQuery(rows int) (string, error) {
+	insertQuery := sq.
+		Insert("visits").
+		Options("OR IGNORE").
+		Columns(insertColumns...)
+
+	// Values are only used to generate placeholders
+	values := make([]interface{}, len(insertColumns))
+	for i := 0; i < rows; i++ {
+		insertQuery = insertQuery.Values(values...)
+	}
+
+	query, _, err := insertQuery.ToSql()
+	return query, err
+}
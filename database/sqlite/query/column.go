@@ -0,0 +1,27 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Return the SQL expression of a property
+// Custom properties are extracted from the properties JSON column as text,
+// so that they compare like other columns, and are empty if missing
+// Other columns and expressions are returned as is
+func columnExpr(property string) string {
+	if key, ok := database.CustomPropertyKey(property); ok {
+		return fmt.Sprintf(`COALESCE(CAST(json_extract(visits.properties, '$."%s"') AS TEXT), '')`, key)
+	}
+	return property
+}
+
+// Return the SQL expressions of properties
+func columnExprs(properties []string) []string {
+	exprs := make([]string, len(properties))
+	for i, property := range properties {
+		exprs[i] = columnExpr(property)
+	}
+	return exprs
+}
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -8,13 +9,14 @@ import (
 	sq "github.com/Masterminds/squirrel"
 
 	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/utils/hll"
 )
 
 // Wrapper for querying a Database struct
-func Count(db *sql.DB, timeRange *database.TimeRange) (*database.Count, error) {
+func Count(ctx context.Context, db *sql.DB, uniqueBy string, timeRange *database.TimeRange, filters []database.Filter) (*database.Count, error) {
 	// Query
 	queryBuilder := sq.
-		Select("COUNT(*) AS total", "COUNT(DISTINCT ip) AS uniqueCount").
+		Select("COUNT(*) AS total").
 		From("visits")
 
 	// Add time constraints if timeRange provided
@@ -29,17 +31,31 @@ func Count(db *sql.DB, timeRange *database.TimeRange) (*database.Count, error) {
 		}
 	}
 
-	query, _, err := queryBuilder.ToSql()
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	query, args, err := queryBuilder.ToSql()
 	if err != nil {
 		return nil, err
 	}
 
 	// Exec query
 	count := database.Count{}
-	err = db.QueryRow(query).Scan(&count.Total, &count.Unique)
+	err = db.QueryRowContext(ctx, query, args...).Scan(&count.Total)
 	if err != nil {
 		return nil, err
 	}
 
+	// Count unique visitors along with a sketch to merge shards
+	uniques, err := uniqueCounts(ctx, db, nil, uniqueBy, timeRange, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	count.UniqueCount = uniques[""]
+	if count.Sketch == nil {
+		count.Sketch = hll.New()
+	}
+
 	return &count, nil
 }
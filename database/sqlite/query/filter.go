@@ -0,0 +1,42 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Escape GLOB wildcards so that a value is matched literally
+var globEscaper = strings.NewReplacer(`*`, `[*]`, `?`, `[?]`, `[`, `[[]`)
+
+// Add filters constraints to a query as parameterized WHERE clauses
+// Columns are prefixed with the table name to avoid ambiguities in joins
+// and custom properties are read from the properties JSON column
+func addFilters(queryBuilder sq.SelectBuilder, filters []database.Filter) sq.SelectBuilder {
+	for _, filter := range filters {
+		column := fmt.Sprintf("visits.%s", filter.Property)
+		if _, ok := database.CustomPropertyKey(filter.Property); ok {
+			column = columnExpr(filter.Property)
+		}
+
+		switch filter.Operator {
+		case database.FilterEqual:
+			queryBuilder = queryBuilder.Where(sq.Eq{column: filter.Values})
+		case database.FilterNotEqual:
+			queryBuilder = queryBuilder.Where(sq.NotEq{column: filter.Values})
+		case database.FilterPrefix:
+			prefixes := sq.Or{}
+			for _, value := range filter.Values {
+				// GLOB is case sensitive, as are other operators
+				globQuery := fmt.Sprintf("%s GLOB ?", column)
+				prefixes = append(prefixes, sq.Expr(globQuery, globEscaper.Replace(value)+"*"))
+			}
+			queryBuilder = queryBuilder.Where(prefixes)
+		}
+	}
+
+	return queryBuilder
+}
@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Return the analytics of a Database struct matching steps of a funnel,
+// by visitor and in time order, visitors being identified as for uniqueCounts
+func FunnelEvents(ctx context.Context, db *sql.DB, uniqueBy string, steps []database.FunnelStep, timeRange *database.TimeRange, filters []database.Filter) (database.FunnelEvents, error) {
+	// Only read analytics matching a step
+	matchSteps := sq.Or{}
+	for _, step := range steps {
+		matchStep := sq.Eq{}
+		if len(step.Event) > 0 {
+			matchStep["visits.event"] = step.Event
+		}
+		if len(step.Path) > 0 {
+			matchStep["visits.path"] = step.Path
+		}
+		matchSteps = append(matchSteps, matchStep)
+	}
+
+	queryBuilder := sq.
+		Select(visitorColumn(uniqueBy), "time", "event", "path").
+		From("visits").
+		Where(matchSteps)
+
+	queryBuilder = addVisitorFilter(queryBuilder, uniqueBy)
+
+	// Add time constraints if timeRange provided
+	if timeRange != nil {
+		if !timeRange.Start.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+		if !timeRange.End.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+	}
+
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	query, args, err := queryBuilder.OrderBy(visitorColumn(uniqueBy), "time", "rowid").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exec query
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := database.FunnelEvents{}
+	for rows.Next() {
+		var visitor, event, path string
+		var timestamp int64
+		if err := rows.Scan(&visitor, &timestamp, &event, &path); err != nil {
+			return nil, err
+		}
+
+		// Mark every step matched by the analytic
+		var matched uint
+		for i, step := range steps {
+			if step.Match(event, path) {
+				matched |= 1 << uint(i)
+			}
+		}
+
+		events[visitor] = append(events[visitor], database.FunnelEvent{
+			Time:  timestamp,
+			Steps: matched,
+		})
+	}
+
+	return events, rows.Err()
+}
@@ -1,8 +1,10 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -11,11 +13,13 @@ import (
 	"github.com/GitbookIO/micro-analytics/utils/geoip"
 )
 
-// Wrapper for querying a Database struct grouped by a property
-func GroupBy(db *sql.DB, property string, timeRange *database.TimeRange) (*database.Aggregates, error) {
+// Wrapper for querying a Database struct grouped by one or more properties
+func GroupBy(ctx context.Context, db *sql.DB, properties []string, timeRange *database.TimeRange, filters []database.Filter) (*database.Aggregates, error) {
 	// Query
+	groupColumns := columnExprs(properties)
+	columns := append(append([]string{}, groupColumns...), "COUNT(*)")
 	queryBuilder := sq.
-		Select(property, "COUNT(*)").
+		Select(columns...).
 		From("visits")
 
 	// Add time constraints if timeRange provided
@@ -30,14 +34,17 @@ func GroupBy(db *sql.DB, property string, timeRange *database.TimeRange) (*datab
 		}
 	}
 
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
 	// Set query Group By condition
-	query, _, err := queryBuilder.GroupBy(property).ToSql()
+	query, args, err := queryBuilder.GroupBy(groupColumns...).ToSql()
 	if err != nil {
 		return nil, err
 	}
 
 	// Exec query
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -46,98 +53,69 @@ func GroupBy(db *sql.DB, property string, timeRange *database.TimeRange) (*datab
 	list := database.Aggregates{}
 	for rows.Next() {
 		aggregate := database.Aggregate{}
-		rows.Scan(&aggregate.Id, &aggregate.Total)
+		ids := make([]string, len(properties))
 
-		// For countries, get fullname as Label
-		if property == "countryCode" {
-			aggregate.Label = geoip.GetCountry(aggregate.Id)
-		} else {
-			aggregate.Label = aggregate.Id
+		dest := make([]interface{}, 0, len(properties)+1)
+		for i := range ids {
+			dest = append(dest, &ids[i])
+		}
+		dest = append(dest, &aggregate.Total)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
 		}
 
+		setAggregateIds(&aggregate, properties, ids)
 		list.List = append(list.List, aggregate)
 	}
 
+	// Rows iteration stops early if the query is canceled
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return &list, nil
 }
 
-// Wrapper for querying a Database struct grouped by a property
-func GroupByUniq(db *sql.DB, property string, timeRange *database.TimeRange) (*database.Aggregates, error) {
-	// Subquery for counting unique IPs
-	subqueryBuilder := sq.
-		Select(property, "COUNT(DISTINCT ip) AS uniqueCount").
-		From("visits")
-
-	// Add time constraints if timeRange provided
-	if timeRange != nil {
-		if !timeRange.Start.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
-			subqueryBuilder = subqueryBuilder.Where(timeQuery)
-		}
-		if !timeRange.End.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
-			subqueryBuilder = subqueryBuilder.Where(timeQuery)
-		}
-	}
-
-	// Format subquery
-	subquery, _, err := subqueryBuilder.GroupBy(property).ToSql()
+// Wrapper for querying a Database struct grouped by one or more properties
+// with unique visitors counted for each group
+func GroupByUniq(ctx context.Context, db *sql.DB, properties []string, uniqueBy string, timeRange *database.TimeRange, filters []database.Filter) (*database.Aggregates, error) {
+	// Query totals
+	list, err := GroupBy(ctx, db, properties, timeRange, filters)
 	if err != nil {
 		return nil, err
 	}
 
-	subquery = fmt.Sprintf("(%s) AS subquery", subquery)
-
-	// Query
-	tableProperty := fmt.Sprintf("visits.%s", property)
-	subqueryProperty := fmt.Sprintf("subquery.%s", property)
-	joinClause := fmt.Sprintf("%s ON %s = %s", subquery, tableProperty, subqueryProperty)
-
-	queryBuilder := sq.
-		Select(tableProperty, "COUNT(*) AS total", "uniqueCount").
-		From("visits").
-		Join(joinClause)
-
-	// Add time constraints if timeRange provided
-	if timeRange != nil {
-		if !timeRange.Start.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
-			queryBuilder = queryBuilder.Where(timeQuery)
-		}
-		if !timeRange.End.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
-			queryBuilder = queryBuilder.Where(timeQuery)
-		}
-	}
-
-	// Format query
-	query, _, err := queryBuilder.GroupBy(tableProperty).ToSql()
+	// Count unique visitors along with a sketch to merge shards
+	uniques, err := uniqueCounts(ctx, db, properties, uniqueBy, timeRange, filters)
 	if err != nil {
 		return nil, err
 	}
 
-	// Exec query
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
+	for i, aggregate := range list.List {
+		list.List[i].UniqueCount = uniques[aggregate.Key()]
 	}
-	defer rows.Close()
 
-	// Format results
-	list := database.Aggregates{}
-	for rows.Next() {
-		aggregate := database.Aggregate{}
-		rows.Scan(&aggregate.Id, &aggregate.Total, &aggregate.Unique)
+	return list, nil
+}
 
+// Set Id and Label of an Aggregate from the grouped properties values
+// Multi-dimensional Aggregates also get the tuples as Ids and Labels
+func setAggregateIds(aggregate *database.Aggregate, properties []string, ids []string) {
+	labels := make([]string, len(ids))
+	for i, id := range ids {
 		// For countries, get fullname as Label
-		if property == "countryCode" {
-			aggregate.Label = geoip.GetCountry(aggregate.Id)
+		if properties[i] == "countryCode" {
+			labels[i] = geoip.GetCountry(id)
 		} else {
-			aggregate.Label = aggregate.Id
+			labels[i] = id
 		}
-
-		list.List = append(list.List, aggregate)
 	}
 
-	return &list, nil
+	aggregate.Id = strings.Join(ids, database.AggregateIdSeparator)
+	aggregate.Label = strings.Join(labels, database.AggregateLabelSeparator)
+
+	if len(properties) > 1 {
+		aggregate.Ids = ids
+		aggregate.Labels = labels
+	}
 }
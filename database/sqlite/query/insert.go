@@ -1,7 +1,9 @@
 package query
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 
 	sq "github.com/Masterminds/squirrel"
 
@@ -9,23 +11,49 @@ import (
 )
 
 // Wrapper for inserting through a Database struct
-func Insert(db *sql.DB, analytic database.Analytic) error {
+// The analytic is ignored if one with the same id was already inserted
+func Insert(ctx context.Context, db *sql.DB, analytic database.Analytic) error {
 	insertQuery := sq.
 		Insert("visits").
-		Columns("time", "event", "path", "ip", "platform", "refererDomain", "countryCode").
+		Options("OR IGNORE").
+		Columns(insertColumns...).
 		Values(analytic.Time.Unix(),
 		analytic.Event,
 		analytic.Path,
 		analytic.Ip,
 		analytic.Platform,
 		analytic.RefererDomain,
-		analytic.CountryCode).
+		analytic.CountryCode,
+		nullableId(analytic.Id),
+		nullableProperties(analytic.Properties),
+		analytic.UserId,
+		analytic.SessionId).
 		RunWith(db)
 
-	_, err := insertQuery.Exec()
+	_, err := insertQuery.ExecContext(ctx)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Store analytics without id as NULL, so that they don't conflict
+func nullableId(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// Store custom properties as a JSON object, or NULL if there are none
+func nullableProperties(properties map[string]interface{}) interface{} {
+	if len(properties) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
@@ -1,7 +1,9 @@
 package query
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -11,10 +13,35 @@ import (
 )
 
 // Wrapper for querying a Database struct
-func Query(db *sql.DB, timeRange *database.TimeRange) (*database.Analytics, error) {
+func Query(ctx context.Context, db *sql.DB, timeRange *database.TimeRange, filters []database.Filter) (*database.Analytics, error) {
+	analytics := database.Analytics{}
+	err := QueryEach(ctx, db, timeRange, filters, func(analytic database.Analytic) error {
+		analytics.List = append(analytics.List, analytic)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &analytics, nil
+}
+
+// Call fn for each analytic of a Database struct as rows are scanned, ordered by time
+// An error returned by fn stops the iteration and is returned
+func QueryEach(ctx context.Context, db *sql.DB, timeRange *database.TimeRange, filters []database.Filter, fn func(database.Analytic) error) error {
+	return QueryPage(ctx, db, timeRange, filters, database.OrderAsc, nil, 0, func(analytic database.Analytic, _ database.Cursor) error {
+		return fn(analytic)
+	})
+}
+
+// Call fn for at most limit analytics of a Database struct following after, if provided
+// Analytics are ordered by time then rowid, which keeps the order stable between equal times
+// fn also receives the position of each analytic, to resume listing from it
+// No limit is applied if limit is 0
+func QueryPage(ctx context.Context, db *sql.DB, timeRange *database.TimeRange, filters []database.Filter, order string, after *database.Cursor, limit int, fn func(database.Analytic, database.Cursor) error) error {
 	// Query
 	queryBuilder := sq.
-		Select("time", "event", "path", "ip", "platform", "refererDomain", "countryCode").
+		Select("rowid", "time", "event", "path", "ip", "platform", "refererDomain", "countryCode", "id", "properties", "userId", "sessionId").
 		From("visits")
 
 	// Add time constraints if timeRange provided
@@ -29,33 +56,73 @@ func Query(db *sql.DB, timeRange *database.TimeRange) (*database.Analytics, erro
 		}
 	}
 
-	query, _, err := queryBuilder.ToSql()
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	// Start after cursor position if provided
+	direction := "ASC"
+	comparison := ">"
+	if order == database.OrderDesc {
+		direction = "DESC"
+		comparison = "<"
+	}
+
+	if after != nil {
+		cursorQuery := fmt.Sprintf("(time %s ? OR (time = ? AND rowid %s ?))", comparison, comparison)
+		queryBuilder = queryBuilder.Where(cursorQuery, after.Time, after.Time, after.RowId)
+	}
+
+	queryBuilder = queryBuilder.OrderBy("time "+direction, "rowid "+direction)
+	if limit > 0 {
+		queryBuilder = queryBuilder.Limit(uint64(limit))
+	}
+
+	query, args, err := queryBuilder.ToSql()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Exec query
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	analytics := database.Analytics{}
 	for rows.Next() {
 		analytic := database.Analytic{}
-		var analyticTime int64
-		rows.Scan(&analyticTime,
+		position := database.Cursor{}
+		var id, properties, userId, sessionId sql.NullString
+		err := rows.Scan(&position.RowId,
+			&position.Time,
 			&analytic.Event,
 			&analytic.Path,
 			&analytic.Ip,
 			&analytic.Platform,
 			&analytic.RefererDomain,
-			&analytic.CountryCode)
+			&analytic.CountryCode,
+			&id,
+			&properties,
+			&userId,
+			&sessionId)
+		if err != nil {
+			return err
+		}
 
-		analytic.Time = time.Unix(analyticTime, 0).UTC()
-		analytics.List = append(analytics.List, analytic)
+		analytic.Id = id.String
+		analytic.UserId = userId.String
+		analytic.SessionId = sessionId.String
+		if properties.Valid {
+			if err := json.Unmarshal([]byte(properties.String), &analytic.Properties); err != nil {
+				return err
+			}
+		}
+		analytic.Time = time.Unix(position.Time, 0).UTC()
+		if err := fn(analytic, position); err != nil {
+			return err
+		}
 	}
 
-	return &analytics, nil
+	// Rows iteration stops early if the query is canceled
+	return rows.Err()
 }
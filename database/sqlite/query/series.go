@@ -1,8 +1,11 @@
 package query
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -11,10 +14,63 @@ import (
 )
 
 // Wrapper for querying a Database struct over a time interval
-func Series(db *sql.DB, interval int, timeRange *database.TimeRange) (*database.Intervals, error) {
+func Series(ctx context.Context, db *sql.DB, interval database.SeriesInterval, timeRange *database.TimeRange, filters []database.Filter) (*database.Intervals, error) {
+	startTimeColumn, err := seriesStartColumn(ctx, db, interval, timeRange, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return series(ctx, db, startTimeColumn, interval, timeRange, filters)
+}
+
+// Wrapper for querying a Database struct over a time interval
+// with unique visitors counted for each interval
+func SeriesUniq(ctx context.Context, db *sql.DB, interval database.SeriesInterval, uniqueBy string, timeRange *database.TimeRange, filters []database.Filter) (*database.Intervals, error) {
+	startTimeColumn, err := seriesStartColumn(ctx, db, interval, timeRange, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query totals
+	intervals, err := series(ctx, db, startTimeColumn, interval, timeRange, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing to count
+	if len(intervals.List) == 0 {
+		return intervals, nil
+	}
+
+	// Count unique visitors along with a sketch to merge shards
+	uniques, err := uniqueCounts(ctx, db, []string{startTimeColumn}, uniqueBy, timeRange, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range intervals.List {
+		startTime, err := time.Parse(time.RFC3339, result.Start)
+		if err != nil {
+			return nil, err
+		}
+		intervals.List[i].UniqueCount = uniques[strconv.FormatInt(startTime.Unix(), 10)]
+	}
+
+	return intervals, nil
+}
+
+// Query totals grouped by startTimeColumn
+func series(ctx context.Context, db *sql.DB, startTimeColumn string, interval database.SeriesInterval, timeRange *database.TimeRange, filters []database.Filter) (*database.Intervals, error) {
+	intervals := database.Intervals{}
+
+	// No analytics to group
+	if startTimeColumn == "" {
+		return &intervals, nil
+	}
+
 	// Query
 	queryBuilder := sq.
-		Select(fmt.Sprintf("(time / %d) * %d AS startTime", interval, interval), "COUNT(*)").
+		Select(startTimeColumn+" AS startTime", "COUNT(*)").
 		From("visits")
 
 	// Add time constraints if timeRange provided
@@ -29,70 +85,60 @@ func Series(db *sql.DB, interval int, timeRange *database.TimeRange) (*database.
 		}
 	}
 
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
 	// Set query Group By condition
-	query, _, err := queryBuilder.GroupBy("startTime").ToSql()
+	query, args, err := queryBuilder.GroupBy("startTime").ToSql()
 	if err != nil {
 		return nil, err
 	}
 
 	// Exec query
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	// Format results
-	intervals := database.Intervals{}
 	for rows.Next() {
 		result := database.Interval{}
-		var startTime int
+		var startTime int64
 
-		rows.Scan(&startTime, &result.Total)
+		if err := rows.Scan(&startTime, &result.Total); err != nil {
+			return nil, err
+		}
 
 		// Format Start and End from TIMESTAMP to ISO time
-		result.Start = time.Unix(int64(startTime), 0).UTC().Format(time.RFC3339)
-		result.End = time.Unix(int64(startTime+interval), 0).UTC().Format(time.RFC3339)
+		start := time.Unix(startTime, 0)
+		result.Start = interval.Format(start)
+		result.End = interval.Format(interval.Next(start))
 
 		intervals.List = append(intervals.List, result)
 	}
 
+	// Rows iteration stops early if the query is canceled
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return &intervals, nil
 }
 
-// Wrapper for querying a Database struct over a time interval
-func SeriesUniq(db *sql.DB, interval int, timeRange *database.TimeRange) (*database.Intervals, error) {
-	// Subquery for counting unique IPs
-	subqueryBuilder := sq.
-		Select(fmt.Sprintf("(time / %d) * %d AS sqStartTime", interval, interval), "COUNT(DISTINCT ip) AS uniqueCount").
-		From("visits")
-
-	// Add time constraints if timeRange provided
-	if timeRange != nil {
-		if !timeRange.Start.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
-			subqueryBuilder = subqueryBuilder.Where(timeQuery)
-		}
-		if !timeRange.End.Equal(time.Time{}) {
-			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
-			subqueryBuilder = subqueryBuilder.Where(timeQuery)
-		}
-	}
-
-	// Format subquery
-	subquery, _, err := subqueryBuilder.GroupBy("sqStartTime").ToSql()
-	if err != nil {
-		return nil, err
+// Return a SQL expression of the start of the bucket containing time, as a TIMESTAMP
+// Calendar buckets don't have a fixed duration, so the expression lists the
+// boundaries of the buckets between the first and last matching analytics
+// An empty expression is returned if no analytics match
+func seriesStartColumn(ctx context.Context, db *sql.DB, interval database.SeriesInterval, timeRange *database.TimeRange, filters []database.Filter) (string, error) {
+	if interval.Calendar == "" {
+		return fmt.Sprintf("(time / %d) * %d", interval.Seconds, interval.Seconds), nil
 	}
 
-	subquery = fmt.Sprintf("(%s) AS subquery", subquery)
-
-	// Query
-	joinClause := fmt.Sprintf("%s ON sqStartTime = startTime", subquery)
+	// Query time bounds
 	queryBuilder := sq.
-		Select(fmt.Sprintf("(time / %d) * %d AS startTime", interval, interval), "COUNT(*) AS total", "uniqueCount").
-		From("visits").
-		Join(joinClause)
+		Select("MIN(time)", "MAX(time)").
+		From("visits")
 
 	// Add time constraints if timeRange provided
 	if timeRange != nil {
@@ -106,33 +152,37 @@ func SeriesUniq(db *sql.DB, interval int, timeRange *database.TimeRange) (*datab
 		}
 	}
 
-	// Set query Group By condition
-	query, _, err := queryBuilder.GroupBy("startTime").ToSql()
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	query, args, err := queryBuilder.ToSql()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	// Exec query
-	rows, err := db.Query(query)
+	var minTime, maxTime sql.NullInt64
+	err = db.QueryRowContext(ctx, query, args...).Scan(&minTime, &maxTime)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rows.Close()
 
-	// Format results
-	intervals := database.Intervals{}
-	for rows.Next() {
-		result := database.Interval{}
-		var startTime int
+	// No analytics
+	if !minTime.Valid || !maxTime.Valid {
+		return "", nil
+	}
 
-		rows.Scan(&startTime, &result.Total, &result.Unique)
+	// Map time to the start of its bucket
+	starts := interval.Starts(time.Unix(minTime.Int64, 0), time.Unix(maxTime.Int64, 0))
 
-		// Format Start and End from TIMESTAMP to ISO time
-		result.Start = time.Unix(int64(startTime), 0).UTC().Format(time.RFC3339)
-		result.End = time.Unix(int64(startTime+interval), 0).UTC().Format(time.RFC3339)
+	if len(starts) == 1 {
+		return strconv.FormatInt(starts[0].Unix(), 10), nil
+	}
 
-		intervals.List = append(intervals.List, result)
+	column := []string{"CASE"}
+	for i := 1; i < len(starts); i++ {
+		column = append(column, fmt.Sprintf("WHEN time < %d THEN %d", starts[i].Unix(), starts[i-1].Unix()))
 	}
+	column = append(column, fmt.Sprintf("ELSE %d END", starts[len(starts)-1].Unix()))
 
-	return &intervals, nil
+	return strings.Join(column, " "), nil
 }
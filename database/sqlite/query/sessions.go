@@ -0,0 +1,95 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Reconstruct the sessions of a Database struct
+// Analytics of a visitor belong to the same session until the visitor is
+// inactive for longer than timeout, visitors being identified as for uniqueCounts
+// The first and last sessions of each visitor are left open in the result
+func Sessions(ctx context.Context, db *sql.DB, uniqueBy string, timeout time.Duration, timeRange *database.TimeRange, filters []database.Filter) (*database.SessionStats, error) {
+	// Query analytics of each visitor in time order
+	queryBuilder := sq.
+		Select(visitorColumn(uniqueBy), "time", "path").
+		From("visits")
+
+	queryBuilder = addVisitorFilter(queryBuilder, uniqueBy)
+
+	// Add time constraints if timeRange provided
+	if timeRange != nil {
+		if !timeRange.Start.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+		if !timeRange.End.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+	}
+
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	query, args, err := queryBuilder.OrderBy(visitorColumn(uniqueBy), "time", "rowid").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exec query
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := database.NewSessionStats()
+
+	// Sessions of the current visitor, the last one being in progress
+	var visitor string
+	sessions := make([]database.SessionSpan, 0)
+
+	for rows.Next() {
+		var rowVisitor, path string
+		var timestamp int64
+		if err := rows.Scan(&rowVisitor, &timestamp, &path); err != nil {
+			return nil, err
+		}
+
+		// Add sessions of the previous visitor
+		if rowVisitor != visitor {
+			stats.AddVisitor(visitor, sessions)
+			visitor = rowVisitor
+			sessions = sessions[:0]
+		}
+
+		// Start a new session after inactivity
+		last := len(sessions) - 1
+		if last < 0 || timestamp-sessions[last].End > int64(timeout.Seconds()) {
+			sessions = append(sessions, database.SessionSpan{
+				Start: timestamp,
+				Entry: path,
+			})
+			last++
+		}
+		sessions[last].End = timestamp
+		sessions[last].Exit = path
+		sessions[last].Pages++
+	}
+
+	// Rows iteration stops early if the query is canceled
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.AddVisitor(visitor, sessions)
+
+	return stats, nil
+}
@@ -0,0 +1,110 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/utils/hll"
+)
+
+// Map identifiers of visitors w/ their column
+var uniqueColumns = map[string]string{
+	database.UniqueByIp:      "ip",
+	database.UniqueByUser:    "userId",
+	database.UniqueBySession: "sessionId",
+}
+
+// Count unique visitors grouped by some columns
+// Visitors are identified by their IP, userId or sessionId according to uniqueBy
+// Results are keyed by the columns values joined as in database.Aggregate.Key()
+// and contain both the exact count for the shard and a mergeable sketch
+func uniqueCounts(ctx context.Context, db *sql.DB, columns []string, uniqueBy string, timeRange *database.TimeRange, filters []database.Filter) (map[string]database.UniqueCount, error) {
+	// Query every distinct set of columns and visitor
+	selectColumns := append(columnExprs(columns), visitorColumn(uniqueBy))
+	queryBuilder := sq.
+		Select(selectColumns...).
+		Distinct().
+		From("visits")
+
+	queryBuilder = addVisitorFilter(queryBuilder, uniqueBy)
+
+	// Add time constraints if timeRange provided
+	if timeRange != nil {
+		if !timeRange.Start.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time >= %d", timeRange.Start.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+		if !timeRange.End.Equal(time.Time{}) {
+			timeQuery := fmt.Sprintf("time <= %d", timeRange.End.Unix())
+			queryBuilder = queryBuilder.Where(timeQuery)
+		}
+	}
+
+	// Add filters constraints if provided
+	queryBuilder = addFilters(queryBuilder, filters)
+
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exec query
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Add each visitor to its group as rows are read,
+	// so that only a sketch per group is kept in memory
+	uniques := make(map[string]database.UniqueCount)
+	for rows.Next() {
+		values := make([]string, len(columns))
+		var visitor string
+
+		dest := make([]interface{}, 0, len(columns)+1)
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		dest = append(dest, &visitor)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		key := strings.Join(values, "\x00")
+		unique, ok := uniques[key]
+		if !ok {
+			unique.Sketch = hll.New()
+		}
+		unique.Unique++
+		unique.Sketch.Add(visitor)
+		uniques[key] = unique
+	}
+
+	return uniques, rows.Err()
+}
+
+// Return the column identifying visitors according to uniqueBy
+// Visitors are identified by IP by default
+func visitorColumn(uniqueBy string) string {
+	column, ok := uniqueColumns[uniqueBy]
+	if !ok {
+		column = uniqueColumns[database.UniqueByIp]
+	}
+	return fmt.Sprintf("visits.%s", column)
+}
+
+// Skip analytics without identifier, including those of old shards,
+// unless visitors are identified by IP
+func addVisitorFilter(queryBuilder sq.SelectBuilder, uniqueBy string) sq.SelectBuilder {
+	if _, ok := uniqueColumns[uniqueBy]; !ok || uniqueBy == database.UniqueByIp {
+		return queryBuilder
+	}
+	return queryBuilder.Where(fmt.Sprintf("%s != ''", visitorColumn(uniqueBy)))
+}
@@ -1,7 +1,8 @@
 package sqlite
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"io/ioutil"
 	"net/url"
 	"sort"
@@ -10,7 +11,6 @@ import (
 	"time"
 
 	"github.com/GitbookIO/diskache"
-	"github.com/GitbookIO/go-sqlpool"
 
 	"github.com/GitbookIO/micro-analytics/database"
 	"github.com/GitbookIO/micro-analytics/database/errors"
@@ -20,9 +20,10 @@ import (
 )
 
 type Sharded struct {
-	DBManager *manager.DBManager
-	directory string
-	cache     *diskache.Diskache
+	DBManager    *manager.DBManager
+	directory    string
+	cache        *diskache.Diskache
+	queryWorkers int
 }
 
 func NewShardedDriver(driverOpts database.DriverOpts) (*Sharded, error) {
@@ -36,318 +37,254 @@ func NewShardedDriver(driverOpts database.DriverOpts) (*Sharded, error) {
 		return nil, err
 	}
 
+	// Number of shards queried concurrently
+	queryWorkers := driverOpts.QueryWorkers
+	if queryWorkers < 1 {
+		queryWorkers = defaultQueryWorkers
+	}
+
 	driver := &Sharded{
-		DBManager: manager,
-		directory: driverOpts.Directory,
-		cache:     cache,
+		DBManager:    manager,
+		directory:    driverOpts.Directory,
+		cache:        cache,
+		queryWorkers: queryWorkers,
 	}
 
 	return driver, nil
 }
 
-func (driver *Sharded) Query(params database.Params) (*database.Analytics, error) {
-	// Construct DBPath
-	dbPath := manager.DBPath{
-		Name:      params.DBName,
-		Directory: driver.directory,
-	}
-
-	// Check if DB file exists
-	dbExists, err := driver.DBManager.DBExists(dbPath)
+func (driver *Sharded) Query(ctx context.Context, params database.Params) (*database.Analytics, error) {
+	shards, err := driver.shardsInRange(params, "Query")
 	if err != nil {
-		driver.DBManager.Logger.Error("Error executing Query/DBExists on DB %s: %v\n", dbPath, err)
-		return nil, &errors.InternalError
+		return nil, err
 	}
 
-	// DB doesn't exist
-	if !dbExists {
-		return nil, &errors.InvalidDatabaseName
+	// Page through shards one by one if asked
+	if params.Limit > 0 || params.Cursor != nil {
+		return driver.queryPage(ctx, params, shards)
 	}
 
-	// At this point, there should be shards to query
-	// Get list of shards by reading directory
-	shards := listShards(dbPath)
-	analytics := database.Analytics{}
-	cachedRequest := cachedRequest(params.URL)
-
 	// Read from each shard
-	for _, shardName := range shards {
+	results := make([]*database.Analytics, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		return driver.cachedShardQuery(ctx, params, s, "Query", &results[i], func(db *sql.DB) (err error) {
+			results[i], err = query.Query(ctx, db, params.TimeRange, params.Filters)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// Don't include shard if not in timerange
-		shardInt, err := shardNameToInt(shardName)
-		if err != nil {
-			return nil, err
+	// Add shard results to analytics
+	analytics := database.Analytics{}
+	for _, shardAnalytics := range results {
+		for _, analytic := range shardAnalytics.List {
+			analytics.List = append(analytics.List, analytic)
 		}
+	}
 
-		startInt, endInt := timeRangeToInt(params.TimeRange)
-		if shardInt < startInt || shardInt > endInt {
-			continue
+	// Shards results are in ascending order
+	if params.Order == database.OrderDesc {
+		list := analytics.List
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
 		}
+	}
 
-		// Get result if is cached
-		var shardAnalytics *database.Analytics
+	return &analytics, nil
+}
 
-		cacheURL, err := formatURLForCache(params.URL, shardInt, startInt, endInt, params.TimeRange)
+// List at most params.Limit analytics following params.Cursor, reading shards in order
+// A Cursor is set on the result if analytics are left after the page
+func (driver *Sharded) queryPage(ctx context.Context, params database.Params, shards []shard) (*database.Analytics, error) {
+	descending := params.Order == database.OrderDesc
+
+	// Start from the cursor shard
+	cursorInt := 0
+	if params.Cursor != nil {
+		var err error
+		cursorInt, err = shardNameToInt(params.Cursor.Shard)
 		if err != nil {
-			return nil, err
+			return nil, &errors.InvalidCursor
 		}
+	}
 
-		cached, inCache := driver.cache.Get(cacheURL)
-		if inCache {
-			err = json.Unmarshal(cached, &shardAnalytics)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error unmarshaling from cache: %v\n", err)
-				return nil, err
-			}
-		} else {
-			// Else query shard
-			// Construct each shard DBPath
-			shardPath := manager.DBPath{
-				Name:      shardName,
-				Directory: dbPath.String(),
-			}
+	pageShards := make([]shard, 0, len(shards))
+	for _, s := range shards {
+		if params.Cursor != nil && ((!descending && s.Int < cursorInt) || (descending && s.Int > cursorInt)) {
+			continue
+		}
+		pageShards = append(pageShards, s)
+	}
 
-			// Get DB shard from manager
-			db, err := driver.DBManager.Acquire(shardPath)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing Query/Acquire on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
-			}
-			defer driver.DBManager.Release(db)
+	// Read latest shards first if descending
+	if descending {
+		for i, j := 0, len(pageShards)-1; i < j; i, j = i+1, j-1 {
+			pageShards[i], pageShards[j] = pageShards[j], pageShards[i]
+		}
+	}
 
-			// Return query result
-			shardAnalytics, err = query.Query(db.DB, params.TimeRange)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing Query on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
+	analytics := database.Analytics{}
+	positions := make([]database.Cursor, 0)
+	for _, s := range pageShards {
+		// Read one more analytic than asked to know if some are left
+		limit := 0
+		if params.Limit > 0 {
+			limit = params.Limit + 1 - len(analytics.List)
+			if limit <= 0 {
+				break
 			}
+		}
 
-			// Set shard result in cache if asked
-			if cachedRequest {
-				if data, err := json.Marshal(shardAnalytics); err == nil {
-					err = driver.cache.Set(cacheURL, data)
-					if err != nil {
-						driver.DBManager.Logger.Error("Error adding to cache: %v\n", err)
-					}
-				}
-			}
+		// Only resume from the cursor position in its own shard
+		var after *database.Cursor
+		if params.Cursor != nil && s.Int == cursorInt {
+			after = params.Cursor
 		}
 
-		// Add shard result to analytics
-		for _, analytic := range shardAnalytics.List {
+		shardName := s.Name
+		err := driver.readShard(ctx, params, s, "Query", after, limit, func(analytic database.Analytic, position database.Cursor) error {
+			position.Shard = shardName
 			analytics.List = append(analytics.List, analytic)
+			positions = append(positions, position)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	if params.Limit > 0 && len(analytics.List) > params.Limit {
+		analytics.List = analytics.List[:params.Limit]
+		analytics.Cursor = positions[params.Limit-1].Encode()
+	}
+
 	return &analytics, nil
 }
 
-func (driver *Sharded) Count(params database.Params) (*database.Count, error) {
-	// Construct DBPath
-	dbPath := manager.DBPath{
-		Name:      params.DBName,
-		Directory: driver.directory,
-	}
-
-	// Check if DB file exists
-	dbExists, err := driver.DBManager.DBExists(dbPath)
+func (driver *Sharded) Stream(ctx context.Context, params database.Params, fn func(database.Analytic) error) error {
+	shards, err := driver.shardsInRange(params, "Stream")
 	if err != nil {
-		driver.DBManager.Logger.Error("Error executing Count/DBExists on DB %s: %v\n", dbPath, err)
-		return nil, &errors.InternalError
+		return err
 	}
 
-	// DB doesn't exist
-	if !dbExists {
-		return nil, &errors.InvalidDatabaseName
+	// Read latest shards first if descending
+	if params.Order == database.OrderDesc {
+		for i, j := 0, len(shards)-1; i < j; i, j = i+1, j-1 {
+			shards[i], shards[j] = shards[j], shards[i]
+		}
 	}
 
-	// At this point, there should be shards to query
-	// Get list of shards by reading directory
-	shards := listShards(dbPath)
-
-	// Aggregated query result
-	analytics := database.Count{}
-
-	cachedRequest := cachedRequest(params.URL)
-
-	// Read from each shard
-	for _, shardName := range shards {
-		// Don't include shard if not in timerange
-		shardInt, err := shardNameToInt(shardName)
+	// Read shards one by one to keep analytics in time order
+	for _, s := range shards {
+		err := driver.readShard(ctx, params, s, "Stream", nil, 0, func(analytic database.Analytic, _ database.Cursor) error {
+			return fn(analytic)
+		})
 		if err != nil {
-			return nil, err
-		}
-
-		startInt, endInt := timeRangeToInt(params.TimeRange)
-		if shardInt < startInt || shardInt > endInt {
-			continue
+			return err
 		}
+	}
 
-		// Get result if is cached
-		var shardAnalytics *database.Count
+	return nil
+}
 
-		cacheURL, err := formatURLForCache(params.URL, shardInt, startInt, endInt, params.TimeRange)
-		if err != nil {
-			return nil, err
-		}
+// Call fn for at most limit analytics of a single shard following after
+// method is only used for logging
+func (driver *Sharded) readShard(ctx context.Context, params database.Params, s shard, method string, after *database.Cursor, limit int, fn func(database.Analytic, database.Cursor) error) error {
+	// Get DB shard from manager
+	db, err := driver.DBManager.Acquire(s.Path)
+	if err != nil {
+		driver.DBManager.Logger.Error("Error executing %s/Acquire on DB %s: %v\n", method, s.Path, err)
+		return &errors.InternalError
+	}
+	defer driver.DBManager.Release(db)
 
-		cached, inCache := driver.cache.Get(cacheURL)
-		if inCache {
-			err = json.Unmarshal(cached, &shardAnalytics)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error unmarshaling from cache: %v\n", err)
-				return nil, err
-			}
-		} else {
-			// Else query shard
-			// Construct each shard DBPath
-			shardPath := manager.DBPath{
-				Name:      shardName,
-				Directory: dbPath.String(),
-			}
+	// Keep errors returned by fn as is
+	var fnErr error
+	err = query.QueryPage(ctx, db.DB, params.TimeRange, params.Filters, params.Order, after, limit, func(analytic database.Analytic, position database.Cursor) error {
+		fnErr = fn(analytic, position)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		driver.DBManager.Logger.Error("Error executing %s on DB %s: %v\n", method, s.Path, err)
+		return queryError(ctx)
+	}
 
-			// Get DB shard from manager
-			db, err := driver.DBManager.Acquire(shardPath)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing Count/Acquire on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
-			}
-			defer driver.DBManager.Release(db)
+	return nil
+}
 
-			// Launch query
-			shardAnalytics, err = query.Count(db.DB, params.TimeRange)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing Count on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
-			}
+func (driver *Sharded) Count(ctx context.Context, params database.Params) (*database.Count, error) {
+	shards, err := driver.shardsInRange(params, "Count")
+	if err != nil {
+		return nil, err
+	}
 
-			// Set shard result in cache if asked
-			if cachedRequest {
-				if data, err := json.Marshal(shardAnalytics); err == nil {
-					err = driver.cache.Set(cacheURL, data)
-					if err != nil {
-						driver.DBManager.Logger.Error("Error adding to cache: %v\n", err)
-					}
-				}
-			}
-		}
+	// Read from each shard
+	results := make([]*database.Count, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		return driver.cachedShardQuery(ctx, params, s, "Count", &results[i], func(db *sql.DB) (err error) {
+			results[i], err = query.Count(ctx, db, params.UniqueBy, params.TimeRange, params.Filters)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
 
+	// Aggregated query result
+	analytics := database.Count{}
+	for _, shardAnalytics := range results {
 		// Add shard result to main result
 		analytics.Total += shardAnalytics.Total
-		analytics.Unique += shardAnalytics.Unique
+		analytics.MergeUnique(shardAnalytics.UniqueCount)
 	}
 
+	analytics.ClearSketch()
 	return &analytics, nil
 }
 
-func (driver *Sharded) GroupBy(params database.Params) (*database.Aggregates, error) {
-	// Construct DBPath
-	dbPath := manager.DBPath{
-		Name:      params.DBName,
-		Directory: driver.directory,
-	}
-
-	// Check if DB file exists
-	dbExists, err := driver.DBManager.DBExists(dbPath)
+func (driver *Sharded) GroupBy(ctx context.Context, params database.Params) (*database.Aggregates, error) {
+	shards, err := driver.shardsInRange(params, "GroupBy")
 	if err != nil {
-		driver.DBManager.Logger.Error("Error executing GroupBy/DBExists on DB %s: %v\n", dbPath, err)
-		return nil, &errors.InternalError
-	}
-
-	// DB doesn't exist
-	if !dbExists {
-		return nil, &errors.InvalidDatabaseName
+		return nil, err
 	}
 
-	// At this point, there should be shards to query
-	// Get list of shards by reading directory
-	shards := listShards(dbPath)
-
-	// Aggregated query result
-	analytics := database.Aggregates{}
-	// Helper map to aggregate
-	analyticsMap := map[string]database.Aggregate{}
-
-	cachedRequest := cachedRequest(params.URL)
-
 	// Read from each shard
-	for _, shardName := range shards {
-		// Don't include shard if not in timerange
-		shardInt, err := shardNameToInt(shardName)
-		if err != nil {
-			return nil, err
-		}
-
-		startInt, endInt := timeRangeToInt(params.TimeRange)
-		if shardInt < startInt || shardInt > endInt {
-			continue
-		}
-
-		// Get result if is cached
-		var shardAnalytics *database.Aggregates
-
-		cacheURL, err := formatURLForCache(params.URL, shardInt, startInt, endInt, params.TimeRange)
-		if err != nil {
-			return nil, err
-		}
-
-		cached, inCache := driver.cache.Get(cacheURL)
-		if inCache {
-			err = json.Unmarshal(cached, &shardAnalytics)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error unmarshaling from cache: %v\n", err)
-				return nil, err
-			}
-		} else {
-			// Else query shard
-			// Construct each shard DBPath
-			shardPath := manager.DBPath{
-				Name:      shardName,
-				Directory: dbPath.String(),
-			}
-
-			// Get DB shard from manager
-			db, err := driver.DBManager.Acquire(shardPath)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing GroupBy/Acquire on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
-			}
-			defer driver.DBManager.Release(db)
-
+	results := make([]*database.Aggregates, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		return driver.cachedShardQuery(ctx, params, s, "GroupBy", &results[i], func(db *sql.DB) (err error) {
 			// Check for unique query parameter to call function accordingly
 			if params.Unique {
-				shardAnalytics, err = query.GroupByUniq(db.DB, params.Property, params.TimeRange)
-				if err != nil {
-					driver.DBManager.Logger.Error("Error executing GroupByUniq on DB %s: %v\n", shardPath, err)
-					return nil, &errors.InternalError
-				}
+				results[i], err = query.GroupByUniq(ctx, db, params.GroupByProperties(), params.UniqueBy, params.TimeRange, params.Filters)
 			} else {
-				shardAnalytics, err = query.GroupBy(db.DB, params.Property, params.TimeRange)
-				if err != nil {
-					driver.DBManager.Logger.Error("Error executing GroupBy on DB %s: %v\n", shardPath, err)
-					return nil, &errors.InternalError
-				}
+				results[i], err = query.GroupBy(ctx, db, params.GroupByProperties(), params.TimeRange, params.Filters)
 			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			// Set shard result in cache if asked
-			if cachedRequest {
-				if data, err := json.Marshal(shardAnalytics); err == nil {
-					err = driver.cache.Set(cacheURL, data)
-					if err != nil {
-						driver.DBManager.Logger.Error("Error adding to cache: %v\n", err)
-					}
-				}
-			}
-		}
+	// Aggregated query result
+	analytics := database.Aggregates{}
+	// Helper map to aggregate
+	analyticsMap := map[string]database.Aggregate{}
 
+	for _, shardAnalytics := range results {
 		// Add shard result to analyticsMap
 		for _, analytic := range shardAnalytics.List {
-			if total, ok := analyticsMap[analytic.Id]; ok {
+			key := analytic.Key()
+			if total, ok := analyticsMap[key]; ok {
 				total.Total += analytic.Total
-				total.Unique += analytic.Unique
-				analyticsMap[analytic.Id] = total
+				total.MergeUnique(analytic.UniqueCount)
+				analyticsMap[key] = total
 			} else {
-				analyticsMap[analytic.Id] = analytic
+				analyticsMap[key] = analytic
 			}
 		}
 	}
@@ -358,123 +295,128 @@ func (driver *Sharded) GroupBy(params database.Params) (*database.Aggregates, er
 		aggregateList = append(aggregateList, analytic)
 	}
 
-	// Sort and set
-	sort.Sort(aggregateList)
+	// Set, sort and paginate
 	analytics.List = aggregateList
+	analytics.ClearSketches()
+	analytics.Sort(params.Sort)
+	analytics.Paginate(params.Offset, params.Limit)
 
 	return &analytics, nil
 }
 
-func (driver *Sharded) Series(params database.Params) (*database.Intervals, error) {
-	// Construct DBPath
-	dbPath := manager.DBPath{
-		Name:      params.DBName,
-		Directory: driver.directory,
-	}
-
-	// Check if DB file exists
-	dbExists, err := driver.DBManager.DBExists(dbPath)
+func (driver *Sharded) Series(ctx context.Context, params database.Params) (*database.Intervals, error) {
+	shards, err := driver.shardsInRange(params, "Series")
 	if err != nil {
-		driver.DBManager.Logger.Error("Error executing Series/DBExists on DB %s: %v\n", dbPath, err)
-		return nil, &errors.InternalError
+		return nil, err
 	}
 
-	// DB doesn't exist
-	if !dbExists {
-		return nil, &errors.InvalidDatabaseName
+	// Read from each shard
+	results := make([]*database.Intervals, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		return driver.cachedShardQuery(ctx, params, s, "Series", &results[i], func(db *sql.DB) (err error) {
+			// Check for unique query parameter to call function accordingly
+			if params.Unique {
+				results[i], err = query.SeriesUniq(ctx, db, params.SeriesInterval(), params.UniqueBy, params.TimeRange, params.Filters)
+			} else {
+				results[i], err = query.Series(ctx, db, params.SeriesInterval(), params.TimeRange, params.Filters)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// At this point, there should be shards to query
-	// Get list of shards by reading directory
-	shards := listShards(dbPath)
-
 	// Aggregated query result
 	analytics := database.Intervals{}
+	for _, shardAnalytics := range results {
+		for _, analytic := range shardAnalytics.List {
+			analytics.List = append(analytics.List, analytic)
+		}
+	}
 
-	cachedRequest := cachedRequest(params.URL)
+	// Merge time series by Start and End date
+	analytics.Merge()
+	analytics.ClearSketches()
 
-	// Read from each shard
-	for _, shardName := range shards {
-		// Don't include shard if not in timerange
-		shardInt, err := shardNameToInt(shardName)
-		if err != nil {
-			return nil, err
-		}
+	// Add missing intervals if asked
+	if err := params.FillIntervals(&analytics); err != nil {
+		return nil, &errors.TooManyIntervals
+	}
 
-		startInt, endInt := timeRangeToInt(params.TimeRange)
-		if shardInt < startInt || shardInt > endInt {
-			continue
-		}
+	return &analytics, nil
+}
 
-		// Get result if is cached
-		var shardAnalytics *database.Intervals
+// Sessions are reconstructed per shard, then sessions of a visitor
+// are joined across shards in chronological order
+func (driver *Sharded) Sessions(ctx context.Context, params database.Params) (*database.Sessions, error) {
+	shards, err := driver.shardsInRange(params, "Sessions")
+	if err != nil {
+		return nil, err
+	}
 
-		cacheURL, err := formatURLForCache(params.URL, shardInt, startInt, endInt, params.TimeRange)
-		if err != nil {
-			return nil, err
-		}
+	// Read from each shard
+	results := make([]*database.SessionStats, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		return driver.cachedShardQuery(ctx, params, s, "Sessions", &results[i], func(db *sql.DB) (err error) {
+			results[i], err = query.Sessions(ctx, db, params.UniqueBy, params.SessionTimeout, params.TimeRange, params.Filters)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		cached, inCache := driver.cache.Get(cacheURL)
-		if inCache {
-			err = json.Unmarshal(cached, &shardAnalytics)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error unmarshaling from cache: %v\n", err)
-				return nil, err
-			}
-		} else {
-			// Else query shard
-			// Construct each shard DBPath
-			shardPath := manager.DBPath{
-				Name:      shardName,
-				Directory: dbPath.String(),
-			}
+	// Merge shards in chronological order
+	stats := database.NewSessionStats()
+	for _, shardStats := range results {
+		stats.Merge(*shardStats, params.SessionTimeout)
+	}
 
-			// Get DB shard from manager
-			db, err := driver.DBManager.Acquire(shardPath)
-			if err != nil {
-				driver.DBManager.Logger.Error("Error executing Series/Acquire on DB %s: %v\n", shardPath, err)
-				return nil, &errors.InternalError
-			}
-			defer driver.DBManager.Release(db)
+	return stats.Sessions(params.Limit), nil
+}
 
-			// Check for unique query parameter to call function accordingly
-			if params.Unique {
-				shardAnalytics, err = query.SeriesUniq(db.DB, params.Interval, params.TimeRange)
-				if err != nil {
-					driver.DBManager.Logger.Error("Error executing SeriesUniq on DB %s: %v\n", shardPath, err)
-					return nil, &errors.InternalError
-				}
-			} else {
-				shardAnalytics, err = query.Series(db.DB, params.Interval, params.TimeRange)
-				if err != nil {
-					driver.DBManager.Logger.Error("Error executing Series on DB %s: %v\n", shardPath, err)
-					return nil, &errors.InternalError
-				}
-			}
+// Analytics of each visitor are read from every shard before going through
+// the funnel, so that visitors can reach steps in different months
+// Shard results are not cached since steps are not part of the URL
+func (driver *Sharded) Funnel(ctx context.Context, params database.Params) (*database.Funnel, error) {
+	shards, err := driver.shardsInRange(params, "Funnel")
+	if err != nil {
+		return nil, err
+	}
 
-			// Set shard result in cache if asked
-			if cachedRequest {
-				if data, err := json.Marshal(shardAnalytics); err == nil {
-					err = driver.cache.Set(cacheURL, data)
-					if err != nil {
-						driver.DBManager.Logger.Error("Error adding to cache: %v\n", err)
-					}
-				}
-			}
+	// Read from each shard
+	results := make([]database.FunnelEvents, len(shards))
+	err = driver.fanOut(ctx, shards, func(i int, s shard) error {
+		// Get DB shard from manager
+		db, err := driver.DBManager.Acquire(s.Path)
+		if err != nil {
+			driver.DBManager.Logger.Error("Error executing Funnel/Acquire on DB %s: %v\n", s.Path, err)
+			return &errors.InternalError
 		}
+		defer driver.DBManager.Release(db)
 
-		// Add shard result to analyticsMap
-		for _, analytic := range shardAnalytics.List {
-			analytics.List = append(analytics.List, analytic)
+		results[i], err = query.FunnelEvents(ctx, db.DB, params.UniqueBy, params.FunnelSteps, params.TimeRange, params.Filters)
+		if err != nil {
+			driver.DBManager.Logger.Error("Error executing Funnel on DB %s: %v\n", s.Path, err)
+			return queryError(ctx)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Merge time series by Start and End date
-	analytics.Merge()
-	return &analytics, nil
+	// Merge shards in chronological order
+	events := database.FunnelEvents{}
+	for _, shardEvents := range results {
+		events.Merge(shardEvents)
+	}
+
+	return events.Funnel(params.FunnelSteps, params.FunnelWindow), nil
 }
 
-func (driver *Sharded) Insert(params database.Params, analytic database.Analytic) error {
+func (driver *Sharded) Insert(ctx context.Context, params database.Params, analytic database.Analytic) error {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -499,7 +441,7 @@ func (driver *Sharded) Insert(params database.Params, analytic database.Analytic
 	defer driver.DBManager.Release(db)
 
 	// Insert data if everything's OK
-	err = query.Insert(db.DB, analytic)
+	err = query.Insert(ctx, db.DB, analytic)
 
 	if err != nil {
 		driver.DBManager.Logger.Error("Error executing Insert on DB %s: %v\n", shardPath, err)
@@ -509,59 +451,47 @@ func (driver *Sharded) Insert(params database.Params, analytic database.Analytic
 	return nil
 }
 
-func (driver *Sharded) BulkInsert(analytics map[string][]database.Analytic) error {
-	var acquireErr, insertErr error
-	var db *sqlpool.Resource
-	// Run a bulk insert query for each database
+// Insert analytics shard by shard
+// If atomic, analytics are inserted in all shards or in none of them
+// Otherwise a shard failing doesn't prevent inserting others
+// Failed analytics are listed by their position in a returned *errors.BulkInsertError
+func (driver *Sharded) BulkInsert(ctx context.Context, analytics map[string][]database.Analytic, atomic bool) error {
+	inserts := make([]fileInsert, 0)
 	for dbName, _analytics := range analytics {
-		// Group database analytics by shards
-		shardedAnalytics := make(map[string][]database.Analytic)
-		for _, analytic := range _analytics {
-			shardName := timeToShardName(analytic.Time)
-			shardedAnalytics[shardName] = append(shardedAnalytics[shardName], analytic)
+		// Construct DBPath
+		dbPath := manager.DBPath{
+			Name:      dbName,
+			Directory: driver.directory,
 		}
 
-		// Run a bulk insert query for each shard
-		for shardName, shardAnalytics := range shardedAnalytics {
-			// Construct DBPath
-			dbPath := manager.DBPath{
-				Name:      dbName,
-				Directory: driver.directory,
-			}
-
-			// Construct shard DBPath
-			shardPath := manager.DBPath{
-				Name:      shardName,
-				Directory: dbPath.String(),
-			}
-
-			// Get DB from manager
-			db, acquireErr = driver.DBManager.Acquire(shardPath)
-			if acquireErr != nil {
-				driver.DBManager.Logger.Error("Error executing Insert/Acquire on DB %s: %v\n", shardPath, acquireErr)
-				continue
-			}
-			defer driver.DBManager.Release(db)
-
-			// Insert data if everything's OK
-			insertErr = query.BulkInsert(db.DB, shardAnalytics)
-			if insertErr != nil {
-				driver.DBManager.Logger.Error("Error executing Insert on DB %s: %v\n", shardPath, insertErr)
+		// Group database analytics by shards, keeping their positions
+		shardInserts := make(map[string]int)
+		for i, analytic := range _analytics {
+			shardName := timeToShardName(analytic.Time)
+			j, ok := shardInserts[shardName]
+			if !ok {
+				j = len(inserts)
+				shardInserts[shardName] = j
+				inserts = append(inserts, fileInsert{
+					DBName: dbName,
+					Path: manager.DBPath{
+						Name:      shardName,
+						Directory: dbPath.String(),
+					},
+				})
 			}
+			inserts[j].Analytics = append(inserts[j].Analytics, analytic)
+			inserts[j].Positions = append(inserts[j].Positions, i)
 		}
 	}
 
-	if insertErr != nil {
-		return &errors.InsertFailed
+	if atomic {
+		return bulkInsertFilesAtomic(ctx, driver.DBManager, inserts)
 	}
-	if acquireErr != nil {
-		return &errors.InternalError
-	}
-
-	return nil
+	return bulkInsertFiles(ctx, driver.DBManager, inserts)
 }
 
-func (driver *Sharded) Delete(params database.Params) error {
+func (driver *Sharded) Delete(ctx context.Context, params database.Params) error {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -647,7 +577,8 @@ func timeRangeToInt(timeRange *database.TimeRange) (int, int) {
 
 // Format URL for a specific shard
 // Basically, remove start/end if is is before/after shard time
-func formatURLForCache(uRL *url.URL, shardName int, startMonth int, endMonth int, timeRange *database.TimeRange) (string, error) {
+// and add the parsed filters in a canonical order
+func formatURLForCache(uRL *url.URL, shardName int, startMonth int, endMonth int, timeRange *database.TimeRange, filters []database.Filter) (string, error) {
 	// Extract URL query parameters
 	queryParams := uRL.Query()
 
@@ -676,6 +607,17 @@ func formatURLForCache(uRL *url.URL, shardName int, startMonth int, endMonth int
 		queryParams.Del("cache")
 	}
 
+	// Add filter=filter.String() query parameters
+	filterKeys := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		filterKeys = append(filterKeys, filter.String())
+	}
+	sort.Strings(filterKeys)
+	queryParams.Del("filter")
+	for _, filterKey := range filterKeys {
+		queryParams.Add("filter", filterKey)
+	}
+
 	// Add shard=shardName query parameter
 	queryParams.Add("shard", strconv.Itoa(shardName))
 
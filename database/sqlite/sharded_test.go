@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Create a Sharded driver in a temporary directory
+func newTestDriver(t *testing.T) (*Sharded, func()) {
+	directory, err := ioutil.TempDir("", "micro-analytics-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := NewShardedDriver(database.DriverOpts{
+		Directory:      directory,
+		CacheDirectory: directory + "/cache",
+		MaxDBs:         10,
+		IdleTimeout:    60,
+		ClosingChannel: make(chan bool, 1),
+	})
+	if err != nil {
+		os.RemoveAll(directory)
+		t.Fatal(err)
+	}
+
+	return driver, func() {
+		driver.DBManager.Pool.ForceClose()
+		os.RemoveAll(directory)
+	}
+}
+
+func TestQueryPagesAcrossShards(t *testing.T) {
+	driver, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	// Analytics on both sides of shard boundaries, some at the same time
+	times := []string{
+		"2015-11-30T23:59:59Z",
+		"2015-11-30T23:59:59Z",
+		"2015-12-01T00:00:00Z",
+		"2015-12-15T12:00:00Z",
+		"2015-12-31T23:59:59Z",
+		"2016-01-01T00:00:00Z",
+		"2016-01-01T00:00:00Z",
+	}
+	ctx := context.Background()
+	params := database.Params{DBName: "website"}
+	for i, value := range times {
+		analyticTime, _ := time.Parse(time.RFC3339, value)
+		analytic := database.Analytic{Time: analyticTime, Event: string(rune('a' + i))}
+		if err := driver.Insert(ctx, params, analytic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		order  string
+		limit  int
+		events string
+	}{
+		{database.OrderAsc, 1, "abcdefg"},
+		{database.OrderAsc, 2, "abcdefg"},
+		{database.OrderAsc, 3, "abcdefg"},
+		{database.OrderAsc, 7, "abcdefg"},
+		{database.OrderAsc, 10, "abcdefg"},
+		{database.OrderDesc, 1, "gfedcba"},
+		{database.OrderDesc, 2, "gfedcba"},
+		{database.OrderDesc, 3, "gfedcba"},
+		{database.OrderDesc, 7, "gfedcba"},
+	}
+
+	for _, test := range tests {
+		events := ""
+		pages := 0
+		var cursor *database.Cursor
+		for {
+			pages++
+			if pages > len(times)+1 {
+				t.Fatalf("%s limit %d: too many pages", test.order, test.limit)
+			}
+
+			page, err := driver.Query(ctx, database.Params{
+				DBName: "website",
+				Limit:  test.limit,
+				Order:  test.order,
+				Cursor: cursor,
+			})
+			if err != nil {
+				t.Fatalf("%s limit %d: %v", test.order, test.limit, err)
+			}
+			if len(page.List) > test.limit {
+				t.Errorf("%s limit %d: page of %d analytics", test.order, test.limit, len(page.List))
+			}
+			for _, analytic := range page.List {
+				events += analytic.Event
+			}
+
+			if page.Cursor == "" {
+				break
+			}
+			cursor, err = database.DecodeCursor(page.Cursor)
+			if err != nil {
+				t.Fatalf("%s limit %d: %v", test.order, test.limit, err)
+			}
+		}
+
+		if events != test.events {
+			t.Errorf("%s limit %d: events %s, want %s", test.order, test.limit, events, test.events)
+		}
+	}
+}
+
+func TestQueryPageInvalidCursorShard(t *testing.T) {
+	driver, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	params := database.Params{DBName: "website"}
+	if err := driver.Insert(ctx, params, database.Analytic{Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := driver.Query(ctx, database.Params{
+		DBName: "website",
+		Limit:  1,
+		Cursor: &database.Cursor{Shard: "not-a-shard"},
+	})
+	if err == nil {
+		t.Error("accepted a cursor with an invalid shard")
+	}
+}
@@ -1,7 +1,7 @@
 package sqlite
 
 import (
-	"github.com/GitbookIO/go-sqlpool"
+	"context"
 
 	"github.com/GitbookIO/micro-analytics/database"
 	"github.com/GitbookIO/micro-analytics/database/errors"
@@ -23,7 +23,7 @@ func NewSimpleDriver(driverOpts database.DriverOpts) *SQLite {
 	}
 }
 
-func (driver *SQLite) Query(params database.Params) (*database.Analytics, error) {
+func (driver *SQLite) Query(ctx context.Context, params database.Params) (*database.Analytics, error) {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -48,16 +48,73 @@ func (driver *SQLite) Query(params database.Params) (*database.Analytics, error)
 	}
 	defer driver.DBManager.Release(db)
 
-	// Return query result
-	analytics, err := query.Query(db.DB, params.TimeRange)
+	// Read one more analytic than asked to know if some are left
+	limit := 0
+	if params.Limit > 0 {
+		limit = params.Limit + 1
+	}
+
+	analytics := database.Analytics{}
+	positions := make([]database.Cursor, 0)
+	err = query.QueryPage(ctx, db.DB, params.TimeRange, params.Filters, params.Order, params.Cursor, limit, func(analytic database.Analytic, position database.Cursor) error {
+		analytics.List = append(analytics.List, analytic)
+		positions = append(positions, position)
+		return nil
+	})
 	if err != nil {
-		return nil, &errors.InternalError
+		return nil, queryError(ctx)
 	}
 
-	return analytics, nil
+	if params.Limit > 0 && len(analytics.List) > params.Limit {
+		analytics.List = analytics.List[:params.Limit]
+		analytics.Cursor = positions[params.Limit-1].Encode()
+	}
+
+	return &analytics, nil
 }
 
-func (driver *SQLite) Count(params database.Params) (*database.Count, error) {
+func (driver *SQLite) Stream(ctx context.Context, params database.Params, fn func(database.Analytic) error) error {
+	// Construct DBPath
+	dbPath := manager.DBPath{
+		Name:      params.DBName,
+		Directory: driver.directory,
+	}
+
+	// Check if DB file exists
+	dbExists, err := driver.DBManager.DBExists(dbPath)
+	if err != nil {
+		return &errors.InternalError
+	}
+
+	// DB doesn't exist
+	if !dbExists {
+		return &errors.InvalidDatabaseName
+	}
+
+	// Get DB from manager
+	db, err := driver.DBManager.Acquire(dbPath)
+	if err != nil {
+		return &errors.InternalError
+	}
+	defer driver.DBManager.Release(db)
+
+	// Keep errors returned by fn as is
+	var fnErr error
+	err = query.QueryPage(ctx, db.DB, params.TimeRange, params.Filters, params.Order, nil, 0, func(analytic database.Analytic, _ database.Cursor) error {
+		fnErr = fn(analytic)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return queryError(ctx)
+	}
+
+	return nil
+}
+
+func (driver *SQLite) Count(ctx context.Context, params database.Params) (*database.Count, error) {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -83,15 +140,16 @@ func (driver *SQLite) Count(params database.Params) (*database.Count, error) {
 	defer driver.DBManager.Release(db)
 
 	// Return query result
-	analytics, err := query.Count(db.DB, params.TimeRange)
+	analytics, err := query.Count(ctx, db.DB, params.UniqueBy, params.TimeRange, params.Filters)
 	if err != nil {
-		return nil, &errors.InternalError
+		return nil, queryError(ctx)
 	}
+	analytics.ClearSketch()
 
 	return analytics, nil
 }
 
-func (driver *SQLite) GroupBy(params database.Params) (*database.Aggregates, error) {
+func (driver *SQLite) GroupBy(ctx context.Context, params database.Params) (*database.Aggregates, error) {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -120,21 +178,26 @@ func (driver *SQLite) GroupBy(params database.Params) (*database.Aggregates, err
 	var analytics *database.Aggregates
 
 	if params.Unique {
-		analytics, err = query.GroupByUniq(db.DB, params.Property, params.TimeRange)
+		analytics, err = query.GroupByUniq(ctx, db.DB, params.GroupByProperties(), params.UniqueBy, params.TimeRange, params.Filters)
 		if err != nil {
-			return nil, &errors.InternalError
+			return nil, queryError(ctx)
 		}
 	} else {
-		analytics, err = query.GroupBy(db.DB, params.Property, params.TimeRange)
+		analytics, err = query.GroupBy(ctx, db.DB, params.GroupByProperties(), params.TimeRange, params.Filters)
 		if err != nil {
-			return nil, &errors.InternalError
+			return nil, queryError(ctx)
 		}
 	}
 
+	// Sort and paginate
+	analytics.ClearSketches()
+	analytics.Sort(params.Sort)
+	analytics.Paginate(params.Offset, params.Limit)
+
 	return analytics, nil
 }
 
-func (driver *SQLite) Series(params database.Params) (*database.Intervals, error) {
+func (driver *SQLite) Series(ctx context.Context, params database.Params) (*database.Intervals, error) {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -163,21 +226,97 @@ func (driver *SQLite) Series(params database.Params) (*database.Intervals, error
 	var analytics *database.Intervals
 
 	if params.Unique {
-		analytics, err = query.SeriesUniq(db.DB, params.Interval, params.TimeRange)
+		analytics, err = query.SeriesUniq(ctx, db.DB, params.SeriesInterval(), params.UniqueBy, params.TimeRange, params.Filters)
 		if err != nil {
-			return nil, &errors.InternalError
+			return nil, queryError(ctx)
 		}
 	} else {
-		analytics, err = query.Series(db.DB, params.Interval, params.TimeRange)
+		analytics, err = query.Series(ctx, db.DB, params.SeriesInterval(), params.TimeRange, params.Filters)
 		if err != nil {
-			return nil, &errors.InternalError
+			return nil, queryError(ctx)
 		}
 	}
 
+	analytics.Sort()
+	analytics.ClearSketches()
+
+	// Add missing intervals if asked
+	if err := params.FillIntervals(analytics); err != nil {
+		return nil, &errors.TooManyIntervals
+	}
+
 	return analytics, nil
 }
 
-func (driver *SQLite) Insert(params database.Params, analytic database.Analytic) error {
+func (driver *SQLite) Sessions(ctx context.Context, params database.Params) (*database.Sessions, error) {
+	// Construct DBPath
+	dbPath := manager.DBPath{
+		Name:      params.DBName,
+		Directory: driver.directory,
+	}
+
+	// Check if DB file exists
+	dbExists, err := driver.DBManager.DBExists(dbPath)
+	if err != nil {
+		return nil, &errors.InternalError
+	}
+
+	// DB doesn't exist
+	if !dbExists {
+		return nil, &errors.InvalidDatabaseName
+	}
+
+	// Get DB from manager
+	db, err := driver.DBManager.Acquire(dbPath)
+	if err != nil {
+		return nil, &errors.InternalError
+	}
+	defer driver.DBManager.Release(db)
+
+	// Return query result
+	stats, err := query.Sessions(ctx, db.DB, params.UniqueBy, params.SessionTimeout, params.TimeRange, params.Filters)
+	if err != nil {
+		return nil, queryError(ctx)
+	}
+
+	return stats.Sessions(params.Limit), nil
+}
+
+func (driver *SQLite) Funnel(ctx context.Context, params database.Params) (*database.Funnel, error) {
+	// Construct DBPath
+	dbPath := manager.DBPath{
+		Name:      params.DBName,
+		Directory: driver.directory,
+	}
+
+	// Check if DB file exists
+	dbExists, err := driver.DBManager.DBExists(dbPath)
+	if err != nil {
+		return nil, &errors.InternalError
+	}
+
+	// DB doesn't exist
+	if !dbExists {
+		return nil, &errors.InvalidDatabaseName
+	}
+
+	// Get DB from manager
+	db, err := driver.DBManager.Acquire(dbPath)
+	if err != nil {
+		return nil, &errors.InternalError
+	}
+	defer driver.DBManager.Release(db)
+
+	// Return query result
+	events, err := query.FunnelEvents(ctx, db.DB, params.UniqueBy, params.FunnelSteps, params.TimeRange, params.Filters)
+	if err != nil {
+		return nil, queryError(ctx)
+	}
+
+	return events.Funnel(params.FunnelSteps, params.FunnelWindow), nil
+}
+
+func (driver *SQLite) Insert(ctx context.Context, params database.Params, analytic database.Analytic) error {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -192,7 +331,7 @@ func (driver *SQLite) Insert(params database.Params, analytic database.Analytic)
 	defer driver.DBManager.Release(db)
 
 	// Insert data if everything's OK
-	err = query.Insert(db.DB, analytic)
+	err = query.Insert(ctx, db.DB, analytic)
 
 	if err != nil {
 		return &errors.InsertFailed
@@ -201,40 +340,34 @@ func (driver *SQLite) Insert(params database.Params, analytic database.Analytic)
 	return nil
 }
 
-func (driver *SQLite) BulkInsert(analytics map[string][]database.Analytic) error {
-	var acquireErr, insertErr error
-	var db *sqlpool.Resource
-	// Run a bulk insert query for each database
+// Insert analytics DB by DB
+// If atomic, analytics are inserted in all DBs or in none of them
+// Failed analytics are listed by their position in a returned *errors.BulkInsertError
+func (driver *SQLite) BulkInsert(ctx context.Context, analytics map[string][]database.Analytic, atomic bool) error {
+	inserts := make([]fileInsert, 0, len(analytics))
 	for dbName, _analytics := range analytics {
-		// Construct DBPath
-		dbPath := manager.DBPath{
-			Name:      dbName,
-			Directory: driver.directory,
+		insert := fileInsert{
+			DBName: dbName,
+			Path: manager.DBPath{
+				Name:      dbName,
+				Directory: driver.directory,
+			},
+			Analytics: _analytics,
+			Positions: make([]int, len(_analytics)),
 		}
-
-		// Get DB from manager
-		db, acquireErr = driver.DBManager.Acquire(dbPath)
-		if acquireErr != nil {
-			// Impossible to get database, process next analytics
-			continue
+		for i := range insert.Positions {
+			insert.Positions[i] = i
 		}
-		defer driver.DBManager.Release(db)
-
-		// Insert data if everything's OK
-		insertErr = query.BulkInsert(db.DB, _analytics)
+		inserts = append(inserts, insert)
 	}
 
-	if insertErr != nil {
-		return &errors.InsertFailed
-	}
-	if acquireErr != nil {
-		return &errors.InternalError
+	if atomic {
+		return bulkInsertFilesAtomic(ctx, driver.DBManager, inserts)
 	}
-
-	return nil
+	return bulkInsertFiles(ctx, driver.DBManager, inserts)
 }
 
-func (driver *SQLite) Delete(params database.Params) error {
+func (driver *SQLite) Delete(ctx context.Context, params database.Params) error {
 	// Construct DBPath
 	dbPath := manager.DBPath{
 		Name:      params.DBName,
@@ -2,16 +2,30 @@ package database
 
 import (
 	"net/url"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/GitbookIO/micro-analytics/utils/hll"
 )
 
 type Count struct {
-	Total  int `json:"total"`
-	Unique int `json:"unique"`
+	Total int `json:"total"`
+	UniqueCount
+	Previous *Comparison `json:"previous,omitempty"`
+}
+
+// Number of unique visitors of a result
+// Sketch allows to merge unique visitors of different shards
+// and is only used internally and for caching shard results
+type UniqueCount struct {
+	Unique          int         `json:"unique"`
+	UniqueEstimated bool        `json:"uniqueEstimated"`
+	Sketch          *hll.Sketch `json:"sketch,omitempty"`
 }
 
 type Analytic struct {
+	Id            string    `json:"id,omitempty"`
 	Time          time.Time `json:"time"`
 	Event         string    `json:"event"`
 	Path          string    `json:"path"`
@@ -19,17 +33,40 @@ type Analytic struct {
 	Platform      string    `json:"platform"`
 	RefererDomain string    `json:"refererDomain"`
 	CountryCode   string    `json:"countryCode"`
+	UserId        string    `json:"userId,omitempty"`
+	SessionId     string    `json:"sessionId,omitempty"`
+	// Custom properties, stored as JSON
+	Properties map[string]interface{} `json:"properties,omitempty"`
 }
 
 type Analytics struct {
-	List []Analytic `json:"list"`
+	List   []Analytic `json:"list"`
+	Cursor string     `json:"cursor,omitempty"`
 }
 
 type Aggregate struct {
-	Id     string `json:"id"`
-	Label  string `json:"label"`
-	Total  int    `json:"total"`
-	Unique int    `json:"unique"`
+	Id     string   `json:"id"`
+	Label  string   `json:"label"`
+	Ids    []string `json:"ids,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	Total  int      `json:"total"`
+	UniqueCount
+	Previous *Comparison `json:"previous,omitempty"`
+}
+
+// Separators used to join Ids and Labels of a multi-dimensional Aggregate
+const (
+	AggregateIdSeparator    = ","
+	AggregateLabelSeparator = ", "
+)
+
+// Return a key identifying an Aggregate when merging results
+// Multi-dimensional Aggregates are identified by their tuple of Ids
+func (aggregate Aggregate) Key() string {
+	if len(aggregate.Ids) > 0 {
+		return strings.Join(aggregate.Ids, "\x00")
+	}
+	return aggregate.Id
 }
 
 type Aggregates struct {
@@ -37,10 +74,14 @@ type Aggregates struct {
 }
 
 type Interval struct {
-	Start  string `json:"start"`
-	End    string `json:"end"`
-	Total  int    `json:"total"`
-	Unique int    `json:"unique"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Total int    `json:"total"`
+	UniqueCount
+	Previous *Comparison `json:"previous,omitempty"`
+
+	// Set on Intervals added when filling a time series with null values
+	Null bool `json:"-"`
 }
 
 type Intervals struct {
@@ -48,12 +89,93 @@ type Intervals struct {
 }
 
 type Params struct {
-	DBName    string
-	Interval  int
-	Property  string
-	TimeRange *TimeRange
-	Unique    bool
-	URL       *url.URL
+	CalendarInterval string
+	Cursor           *Cursor
+	DBName           string
+	Fill             string
+	Filters          []Filter
+	FunnelSteps      []FunnelStep
+	FunnelWindow     time.Duration
+	Interval         int
+	Limit            int
+	Location         *time.Location
+	Offset           int
+	Order            string
+	Property         string
+	Properties       []string
+	SessionTimeout   time.Duration
+	Sort             string
+	TimeRange        *TimeRange
+	Unique           bool
+	UniqueBy         string
+	URL              *url.URL
+}
+
+// Add the missing buckets of a sorted time series in TimeRange, according to Fill
+func (params Params) FillIntervals(intervals *Intervals) error {
+	var from, to time.Time
+	if params.TimeRange != nil {
+		from = params.TimeRange.Start
+		to = params.TimeRange.End
+	}
+	return intervals.Fill(params.SeriesInterval(), from, to, params.Fill)
+}
+
+// Return the size of time series buckets
+// CalendarInterval takes precedence over Interval
+func (params Params) SeriesInterval() SeriesInterval {
+	return SeriesInterval{
+		Seconds:  params.Interval,
+		Calendar: params.CalendarInterval,
+		Location: params.Location,
+	}
+}
+
+// Return the list of properties to group by
+// Properties takes precedence over Property
+func (params Params) GroupByProperties() []string {
+	if len(params.Properties) > 0 {
+		return params.Properties
+	}
+	return []string{params.Property}
+}
+
+// Orders supported when sorting Aggregates
+const (
+	SortByTotal  = "total"
+	SortByUnique = "unique"
+	SortByLabel  = "label"
+)
+
+// Identifiers of the visitors counted as unique
+const (
+	UniqueByIp      = "ip"
+	UniqueByUser    = "user"
+	UniqueBySession = "session"
+)
+
+// Operators supported by a Filter
+const (
+	FilterEqual    = "="
+	FilterNotEqual = "!="
+	FilterPrefix   = "^="
+)
+
+// Filter restricts a query to rows whose Property matches one of Values
+type Filter struct {
+	Property string
+	Operator string
+	Values   []string
+}
+
+// Print Filter as a canonical string, e.g. path^=%2Fdocs%2F
+// Values are escaped so that the result is unambiguous
+func (filter Filter) String() string {
+	values := make([]string, len(filter.Values))
+	for i, value := range filter.Values {
+		values[i] = url.QueryEscape(value)
+	}
+	return filter.Property + filter.Operator + strings.Join(values, ",")
 }
 
 type TimeRange struct {
@@ -61,6 +183,49 @@ type TimeRange struct {
 	End   time.Time
 }
 
+// Merge the unique visitors of another result into u
+// Sketches are merged when available, otherwise unique counts are summed
+// and the result is marked as estimated
+func (u *UniqueCount) MergeUnique(other UniqueCount) {
+	// Nothing counted yet, use other as is
+	if u.Sketch == nil && u.Unique == 0 {
+		*u = other
+		return
+	}
+
+	if u.Sketch != nil && other.Sketch != nil {
+		u.Sketch.Merge(other.Sketch)
+		u.Unique = u.Sketch.Count()
+		u.UniqueEstimated = !u.Sketch.Exact()
+		return
+	}
+
+	if other.Unique > 0 {
+		u.Sketch = nil
+		u.Unique += other.Unique
+		u.UniqueEstimated = true
+	}
+}
+
+// Remove the Sketch once it is not needed anymore
+func (u *UniqueCount) ClearSketch() {
+	u.Sketch = nil
+}
+
+// Remove the Sketches of every Aggregate
+func (aggregates *Aggregates) ClearSketches() {
+	for i := range aggregates.List {
+		aggregates.List[i].ClearSketch()
+	}
+}
+
+// Remove the Sketches of every Interval
+func (intervals *Intervals) ClearSketches() {
+	for i := range intervals.List {
+		intervals.List[i].ClearSketch()
+	}
+}
+
 // Define an alias of []Aggregate to implement sort
 type AggregateList []Aggregate
 
@@ -71,41 +236,89 @@ func (l AggregateList) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
 
-// AggregateList will be ordered by Total descending
+// AggregateList will be ordered by Total descending, then by Id
 func (l AggregateList) Less(i, j int) bool {
-	return l[i].Total > l[j].Total
+	if l[i].Total != l[j].Total {
+		return l[i].Total > l[j].Total
+	}
+	return l[i].Id < l[j].Id
+}
+
+// Define an alias of AggregateList ordered by Unique descending, then by Id
+type AggregateListByUnique struct {
+	AggregateList
+}
+
+func (l AggregateListByUnique) Less(i, j int) bool {
+	if l.AggregateList[i].Unique != l.AggregateList[j].Unique {
+		return l.AggregateList[i].Unique > l.AggregateList[j].Unique
+	}
+	return l.AggregateList[i].Id < l.AggregateList[j].Id
 }
 
-// Merge Intervals results
+// Define an alias of AggregateList ordered by Label ascending, then by Id
+type AggregateListByLabel struct {
+	AggregateList
+}
+
+func (l AggregateListByLabel) Less(i, j int) bool {
+	if l.AggregateList[i].Label != l.AggregateList[j].Label {
+		return l.AggregateList[i].Label < l.AggregateList[j].Label
+	}
+	return l.AggregateList[i].Id < l.AggregateList[j].Id
+}
+
+// Sort Aggregates using one of the SortBy orders
+// Defaults to SortByTotal
+func (aggregates *Aggregates) Sort(by string) {
+	list := AggregateList(aggregates.List)
+
+	switch by {
+	case SortByUnique:
+		sort.Sort(AggregateListByUnique{list})
+	case SortByLabel:
+		sort.Sort(AggregateListByLabel{list})
+	default:
+		sort.Sort(list)
+	}
+}
+
+// Only keep limit Aggregates starting at offset
+// A limit of 0 keeps every Aggregate after offset
+func (aggregates *Aggregates) Paginate(offset int, limit int) {
+	if offset >= len(aggregates.List) {
+		aggregates.List = []Aggregate{}
+		return
+	}
+	aggregates.List = aggregates.List[offset:]
+
+	if limit > 0 && limit < len(aggregates.List) {
+		aggregates.List = aggregates.List[:limit]
+	}
+}
+
+// Merge Intervals results with the same Start and End, and sort them by Start
 func (intervals *Intervals) Merge() {
-	merged := make([]Interval, 0)
+	merged := make([]Interval, 0, len(intervals.List))
+
+	// Index of each Interval in merged by Start and End
+	indexes := make(map[string]int, len(intervals.List))
 
 	for _, value := range intervals.List {
-		// Markers
-		intervalExists := false
-		atInterval := 0
-
-		// Check that Interval already exists in merged
-		for mIndex, mValue := range merged {
-			compareStart := strings.Compare(value.Start, mValue.Start)
-			compareEnd := strings.Compare(value.End, mValue.End)
-
-			if compareStart == 0 && compareEnd == 0 {
-				intervalExists = true
-				atInterval = mIndex
-			}
-		}
+		key := value.Start + "/" + value.End
 
 		// Add to existing value
-		if intervalExists {
+		if atInterval, intervalExists := indexes[key]; intervalExists {
 			merged[atInterval].Total += value.Total
-			merged[atInterval].Unique += value.Unique
+			merged[atInterval].MergeUnique(value.UniqueCount)
 		} else {
 			// Or append to merged
+			indexes[key] = len(merged)
 			merged = append(merged, value)
 		}
 	}
 
 	// Set intervals.List to merged
 	intervals.List = merged
+	intervals.Sort()
 }
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/azer/logger"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/urfave/cli"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	driverErrors "github.com/GitbookIO/micro-analytics/database/errors"
+	"github.com/GitbookIO/micro-analytics/database/sqlite"
+	"github.com/GitbookIO/micro-analytics/utils/geoip"
+	"github.com/GitbookIO/micro-analytics/web"
+	webErrors "github.com/GitbookIO/micro-analytics/web/errors"
+	"github.com/GitbookIO/micro-analytics/web/structures"
+)
+
+// Formats of imported files
+const (
+	importCSV    = "csv"
+	importNDJSON = "ndjson"
+)
+
+// Map files extensions w/ their format
+var importExtensions = map[string]string{
+	".csv":    importCSV,
+	".ndjson": importNDJSON,
+	".jsonl":  importNDJSON,
+}
+
+// Fields of an analytic that can be read from a column of imported files
+// userAgent and referer are used like the headers of a POST request
+// and properties is a JSON object of custom properties
+var importFields = []string{"id", "website", "time", "event", "path", "ip", "platform", "refererDomain", "countryCode", "userId", "sessionId", "userAgent", "referer", "properties"}
+
+// Maximum length of a line of a NDJSON file
+const maxImportLineLength = 1024 * 1024
+
+func importCommand(log *logger.Logger) cli.Command {
+	return cli.Command{
+		Name:      "import",
+		Usage:     "Import analytics from CSV or NDJSON files directly in the databases",
+		ArgsUsage: "FILE...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "website, s",
+				Value: "",
+				Usage: "Website of the analytics, overrides the website column",
+			},
+			cli.StringFlag{
+				Name:  "format, f",
+				Value: "",
+				Usage: "Format of the files, csv or ndjson, guessed from their extension if empty",
+			},
+			cli.StringSliceFlag{
+				Name:  "map, m",
+				Usage: "Read a field from a column with a different name, as field=column",
+			},
+			cli.IntFlag{
+				Name:  "batch-size, b",
+				Value: 1000,
+				Usage: "Number of analytics inserted together",
+			},
+		},
+		Action: func(ctx *cli.Context) {
+			files := ctx.Args()
+			if len(files) == 0 {
+				log.Error("No file to import")
+				os.Exit(1)
+			}
+
+			mapping, err := parseImportMapping(ctx.StringSlice("map"))
+			if err != nil {
+				log.Error("Invalid mapping [%v]", err)
+				os.Exit(1)
+			}
+
+			cacheDir := path.Clean(ctx.GlobalString("cache-directory"))
+			cacheDir = path.Join(cacheDir, strings.Split(ctx.App.Version, ".")[0])
+
+			// Set driver options
+			driverOpts := database.DriverOpts{
+				Directory:      path.Clean(ctx.GlobalString("root")),
+				CacheDirectory: cacheDir,
+				MaxDBs:         ctx.GlobalInt("connections"),
+				IdleTimeout:    ctx.GlobalInt("idle-timeout"),
+				ClosingChannel: make(chan bool, 1),
+			}
+
+			// Initiate Geolite2 DB Reader
+			geolite2, err := geoip.GetGeoLite2Reader()
+			if err != nil {
+				log.Info("Error [%v] obtaining a geolite2Reader", err)
+				log.Info("Running without Geolite2")
+			}
+
+			// Migrate shards before writing to them
+			if err := migrateAll(log, driverOpts.Directory); err != nil {
+				log.Error("Migration error [%v]", err)
+				os.Exit(1)
+			}
+
+			// Write directly to the shards
+			driver, err := sqlite.NewShardedDriver(driverOpts)
+			if err != nil {
+				log.Error("Driver setup error [%v]", err)
+				os.Exit(1)
+			}
+
+			imp := &importer{
+				driver:    driver,
+				geolite2:  geolite2,
+				log:       log,
+				website:   ctx.String("website"),
+				mapping:   mapping,
+				batchSize: ctx.Int("batch-size"),
+				batch:     make(map[string][]database.Analytic),
+			}
+
+			failed := false
+			for _, file := range files {
+				format := ctx.String("format")
+				if format == "" {
+					format = importExtensions[strings.ToLower(path.Ext(file))]
+				}
+
+				if err := imp.importFile(file, format); err != nil {
+					log.Error("Error importing %s [%v]", file, err)
+					failed = true
+				}
+			}
+
+			log.Info("Imported %d analytics, rejected %d, failed to insert %d", imp.imported, imp.rejected, imp.failed)
+
+			// Close DB connections
+			driver.DBManager.Pool.ForceClose()
+			if geolite2 != nil {
+				geolite2.Close()
+			}
+
+			if failed || imp.failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// Parse field=column mappings into a map of fields w/ their column
+// Fields are read from the column with the same name by default
+func parseImportMapping(mappings []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(importFields))
+	for _, field := range importFields {
+		mapping[field] = field
+	}
+
+	for _, m := range mappings {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("%s is not formatted as field=column", m)
+		}
+		if _, ok := mapping[parts[0]]; !ok {
+			return nil, fmt.Errorf("unknown field %s, use one of %s", parts[0], strings.Join(importFields, ", "))
+		}
+		mapping[parts[0]] = parts[1]
+	}
+
+	return mapping, nil
+}
+
+// Import analytics of files by batches
+type importer struct {
+	driver    database.Driver
+	geolite2  *maxminddb.Reader
+	log       *logger.Logger
+	website   string
+	mapping   map[string]string
+	batchSize int
+
+	// Analytics waiting to be inserted
+	batch      map[string][]database.Analytic
+	batchCount int
+
+	imported int
+	rejected int
+	failed   int
+}
+
+// Import analytics of a file
+// Invalid rows are logged and skipped
+func (imp *importer) importFile(file string, format string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	imp.log.Info("Importing %s as %s", file, format)
+
+	// Readers pass a nil record for rows they can't parse
+	importRecord := func(position string, record map[string]string) error {
+		if record == nil {
+			imp.log.Error("Skipping %s %s [invalid %s]", file, position, format)
+			imp.rejected++
+			return nil
+		}
+
+		analytic, website, err := imp.parseRecord(record)
+		if err != nil {
+			imp.log.Error("Skipping %s %s [%v]", file, position, err)
+			imp.rejected++
+			return nil
+		}
+
+		imp.batch[website] = append(imp.batch[website], analytic)
+		imp.batchCount++
+		if imp.batchCount >= imp.batchSize {
+			return imp.flush()
+		}
+		return nil
+	}
+
+	switch format {
+	case importCSV:
+		err = readCSV(f, importRecord)
+	case importNDJSON:
+		err = readNDJSON(f, importRecord)
+	default:
+		return fmt.Errorf("unknown format %q, use one of %s or %s", format, importCSV, importNDJSON)
+	}
+
+	// Insert analytics read before the end or an error
+	if flushErr := imp.flush(); err == nil {
+		err = flushErr
+	}
+
+	return err
+}
+
+// Build an analytic from a record, enriched as those of POST requests
+func (imp *importer) parseRecord(record map[string]string) (database.Analytic, string, error) {
+	postData := structures.PostAnalytic{
+		Id:            record[imp.mapping["id"]],
+		Website:       record[imp.mapping["website"]],
+		Time:          record[imp.mapping["time"]],
+		Event:         record[imp.mapping["event"]],
+		Path:          record[imp.mapping["path"]],
+		Ip:            record[imp.mapping["ip"]],
+		Platform:      record[imp.mapping["platform"]],
+		RefererDomain: record[imp.mapping["refererDomain"]],
+		CountryCode:   record[imp.mapping["countryCode"]],
+		UserId:        record[imp.mapping["userId"]],
+		SessionId:     record[imp.mapping["sessionId"]],
+		Headers:       make(map[string]string),
+	}
+	if userAgent := record[imp.mapping["userAgent"]]; userAgent != "" {
+		postData.Headers["User-Agent"] = userAgent
+	}
+	if referer := record[imp.mapping["referer"]]; referer != "" {
+		postData.Headers["Referer"] = referer
+	}
+	// Imported analytics would otherwise be recorded at the time of the import
+	if postData.Time == "" {
+		return database.Analytic{}, "", fmt.Errorf("missing time")
+	}
+	if properties := record[imp.mapping["properties"]]; properties != "" {
+		if err := json.Unmarshal([]byte(properties), &postData.Properties); err != nil {
+			return database.Analytic{}, "", fmt.Errorf("invalid properties: %v", err)
+		}
+	}
+
+	website := imp.website
+	if website == "" {
+		website = postData.Website
+	}
+	if website == "" {
+		return database.Analytic{}, "", &webErrors.MissingWebsite
+	}
+
+	analytic, err := web.ParseAnalytic(postData, imp.geolite2, imp.log)
+	return analytic, website, err
+}
+
+// Insert the current batch and report progress
+// Analytics failing to be inserted are counted and dropped with the batch
+func (imp *importer) flush() error {
+	if imp.batchCount == 0 {
+		return nil
+	}
+
+	inserted := imp.batchCount
+	err := imp.driver.BulkInsert(context.Background(), imp.batch, false)
+	if bulkErr, ok := err.(*driverErrors.BulkInsertError); ok {
+		for _, positions := range bulkErr.Failed {
+			inserted -= len(positions)
+			imp.failed += len(positions)
+		}
+		imp.log.Error("Failed to insert %d analytics [%v]", imp.batchCount-inserted, err)
+		err = nil
+	}
+	if err != nil {
+		// Don't insert the batch again along with the next one
+		imp.failed += imp.batchCount
+		imp.batch = make(map[string][]database.Analytic)
+		imp.batchCount = 0
+		return err
+	}
+
+	imp.imported += inserted
+	imp.log.Info("Imported %d analytics", imp.imported)
+
+	imp.batch = make(map[string][]database.Analytic)
+	imp.batchCount = 0
+	return nil
+}
+
+// Call fn for each row of a CSV file with a header, with columns mapped by name
+func readCSV(r io.Reader, fn func(position string, record map[string]string) error) error {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for row := 1; ; row++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		position := fmt.Sprintf("row %d", row)
+
+		// Skip rows with a wrong number of columns
+		if parseErr, ok := err.(*csv.ParseError); ok && parseErr.Err == csv.ErrFieldCount {
+			if err := fn(position, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		record := make(map[string]string, len(header))
+		for i, column := range header {
+			record[column] = values[i]
+		}
+		if err := fn(position, record); err != nil {
+			return err
+		}
+	}
+}
+
+// Call fn for each line of a NDJSON file, with values formatted as strings
+// Objects and arrays are kept as JSON
+func readNDJSON(r io.Reader, fn func(position string, record map[string]string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineLength)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		values := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(scanner.Bytes(), &values); err != nil {
+			values = nil
+		}
+
+		var record map[string]string
+		if values != nil {
+			record = make(map[string]string, len(values))
+			for key, value := range values {
+				record[key] = ndjsonString(value)
+			}
+		}
+
+		if err := fn(fmt.Sprintf("line %d", line), record); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Format a NDJSON value as a string, strings are kept unquoted
+func ndjsonString(value json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(value, &str); err == nil {
+		return str
+	}
+	if string(value) == "null" {
+		return ""
+	}
+	return string(value)
+}
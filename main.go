@@ -6,12 +6,14 @@ import (
 	"path"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/azer/logger"
 	"github.com/urfave/cli"
 	"github.com/facebookgo/grace/gracehttp"
 
 	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/buffer"
 	"github.com/GitbookIO/micro-analytics/utils"
 	"github.com/GitbookIO/micro-analytics/utils/geoip"
 	"github.com/GitbookIO/micro-analytics/web"
@@ -62,6 +64,48 @@ func main() {
 			Usage:  "Idle timeout for DB connections in seconds",
 			EnvVar: "MA_POOL_TIMEOUT",
 		},
+		cli.IntFlag{
+			Name:   "query-workers, q",
+			Value:  4,
+			Usage:  "Max number of shards queried concurrently by a request",
+			EnvVar: "MA_QUERY_WORKERS",
+		},
+		cli.IntFlag{
+			Name:   "query-timeout, t",
+			Value:  60,
+			Usage:  "Timeout for GET queries in seconds, 0 to disable",
+			EnvVar: "MA_QUERY_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "ingest-directory",
+			Value:  "",
+			Usage:  "Directory of the ingest log, inserts are buffered and acknowledged once logged if set",
+			EnvVar: "MA_INGEST_DIR",
+		},
+		cli.IntFlag{
+			Name:   "ingest-flush-size",
+			Value:  1000,
+			Usage:  "Number of buffered analytics triggering a flush to the databases",
+			EnvVar: "MA_INGEST_FLUSH_SIZE",
+		},
+		cli.IntFlag{
+			Name:   "ingest-flush-interval",
+			Value:  1000,
+			Usage:  "Maximum time analytics are buffered in milliseconds",
+			EnvVar: "MA_INGEST_FLUSH_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "trusted-proxy-headers",
+			Value:  "",
+			Usage:  "Comma-separated headers read for the IP of visitors tracked by pixel or beacon, when sent by one of the trusted proxies, e.g. X-Forwarded-For",
+			EnvVar: "MA_TRUSTED_PROXY_HEADERS",
+		},
+		cli.StringFlag{
+			Name:   "trusted-proxies",
+			Value:  "",
+			Usage:  "Comma-separated IP addresses or CIDR ranges of the proxies setting trusted proxy headers, e.g. 10.0.0.0/8",
+			EnvVar: "MA_TRUSTED_PROXIES",
+		},
 		cli.StringFlag{
 			Name:   "cache-directory, d",
 			Value:  ".diskache",
@@ -72,6 +116,12 @@ func main() {
 
 	var log = logger.New("[Main]")
 
+	// Subcommands
+	app.Commands = []cli.Command{
+		importCommand(log),
+		migrateCommand(log),
+	}
+
 	// Main app code
 	app.Action = func(ctx *cli.Context) {
 		cacheDir := path.Clean(ctx.String("cache-directory"))
@@ -84,6 +134,18 @@ func main() {
 			MaxDBs:         ctx.Int("connections"),
 			IdleTimeout:    ctx.Int("idle-timeout"),
 			ClosingChannel: make(chan bool, 1),
+			QueryWorkers:   ctx.Int("query-workers"),
+		}
+
+		// Set ingest buffer options
+		ingestOpts := buffer.Opts{
+			FlushSize:      ctx.Int("ingest-flush-size"),
+			FlushInterval:  time.Duration(ctx.Int("ingest-flush-interval")) * time.Millisecond,
+			ClosingChannel: make(chan bool, 1),
+			ClosedChannel:  make(chan bool, 1),
+		}
+		if len(ctx.String("ingest-directory")) > 0 {
+			ingestOpts.Directory = path.Clean(ctx.String("ingest-directory"))
 		}
 
 		// Create Analytics directory if inexistant
@@ -100,6 +162,12 @@ func main() {
 			log.Info("Working with existing Analytics directory: %s", driverOpts.Directory)
 		}
 
+		// Migrate shards before serving requests
+		if err := migrateAll(log, driverOpts.Directory); err != nil {
+			log.Error("Migration error [%v]", err)
+			os.Exit(1)
+		}
+
 		// Initiate Geolite2 DB Reader
 		geolite2, err := geoip.GetGeoLite2Reader()
 		if err != nil {
@@ -113,6 +181,12 @@ func main() {
 		signal.Notify(c, syscall.SIGTERM)
 		go func() {
 			<-c
+			if len(ingestOpts.Directory) > 0 {
+				log.Info("Flushing ingest buffer...")
+				ingestOpts.ClosingChannel <- true
+				<-ingestOpts.ClosedChannel
+				log.Info("Ingest buffer flushed successfully")
+			}
 			log.Info("Closing database connections...")
 			driverOpts.ClosingChannel <- true
 			<-driverOpts.ClosingChannel
@@ -130,6 +204,21 @@ func main() {
 			Pass: ctx.String("password"),
 		}
 
+		// Headers set by trusted proxies to the IP of visitors
+		trustedProxyHeaders := make([]string, 0)
+		for _, header := range strings.Split(ctx.String("trusted-proxy-headers"), ",") {
+			if header = strings.TrimSpace(header); len(header) > 0 {
+				trustedProxyHeaders = append(trustedProxyHeaders, header)
+			}
+		}
+
+		// Proxies skipped when reading these headers
+		trustedProxies, err := web.ParseTrustedProxies(ctx.String("trusted-proxies"))
+		if err != nil {
+			log.Error("Invalid trusted proxies [%v]", err)
+			os.Exit(1)
+		}
+
 		// Setup server
 		opts := ServerOpts{
 			Port:           normalizePort(ctx.String("port")),
@@ -137,6 +226,11 @@ func main() {
 			DriverOpts:     driverOpts,
 			Geolite2Reader: geolite2,
 			Auth:           auth,
+			QueryTimeout:   time.Duration(ctx.Int("query-timeout")) * time.Second,
+			IngestBuffer:   ingestOpts,
+
+			TrustedProxyHeaders: trustedProxyHeaders,
+			TrustedProxies:      trustedProxies,
 		}
 
 		log.Info("Launching server with: %#v", opts)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/azer/logger"
+	"github.com/urfave/cli"
+
+	"github.com/GitbookIO/micro-analytics/database/sqlite/manager"
+	"github.com/GitbookIO/micro-analytics/utils"
+)
+
+func migrateCommand(log *logger.Logger) cli.Command {
+	return cli.Command{
+		Name:      "migrate",
+		Usage:     "Migrate the schema of every shard to the latest version",
+		ArgsUsage: "[WEBSITE...]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "Only report the migrations pending for each shard",
+			},
+		},
+		Action: func(ctx *cli.Context) {
+			root := path.Clean(ctx.GlobalString("root"))
+			dryRun := ctx.Bool("dry-run")
+
+			// Migrate all websites by default
+			dirs := []string{root}
+			if ctx.NArg() > 0 {
+				dirs = make([]string, 0, ctx.NArg())
+				for _, website := range ctx.Args() {
+					dirs = append(dirs, path.Join(root, website))
+				}
+			}
+
+			shards := make([]string, 0)
+			for _, dir := range dirs {
+				found, err := findShards(dir)
+				if err != nil {
+					log.Error("Error listing shards of %s [%v]", dir, err)
+					os.Exit(1)
+				}
+				shards = append(shards, found...)
+			}
+
+			log.Info("Found %d shards, latest schema version is %d", len(shards), manager.LatestSchemaVersion())
+
+			migrated, upToDate, failed := 0, 0, 0
+			for i, shard := range shards {
+				applied, err := migrateShard(shard, dryRun)
+				switch {
+				case err != nil:
+					log.Error("[%d/%d] %s [%v]", i+1, len(shards), shard, err)
+					failed++
+				case len(applied) == 0:
+					log.Info("[%d/%d] %s is up to date", i+1, len(shards), shard)
+					upToDate++
+				default:
+					verb := "migrated"
+					if dryRun {
+						verb = "would apply"
+					}
+					for _, migration := range applied {
+						log.Info("[%d/%d] %s %s %d: %s", i+1, len(shards), shard, verb, migration.Version, migration.Description)
+					}
+					migrated++
+				}
+			}
+
+			if dryRun {
+				log.Info("%d shards to migrate, %d up to date, %d failed", migrated, upToDate, failed)
+			} else {
+				log.Info("%d shards migrated, %d up to date, %d failed", migrated, upToDate, failed)
+			}
+
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// Migrate every shard in root before serving requests
+// so that shards are never migrated while being queried
+func migrateAll(log *logger.Logger, root string) error {
+	exists, err := utils.PathExists(root)
+	if err != nil || !exists {
+		return err
+	}
+
+	shards, err := findShards(root)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, shard := range shards {
+		applied, err := migrateShard(shard, false)
+		if err != nil {
+			log.Error("Error migrating %s [%v]", shard, err)
+			failed++
+			continue
+		}
+		for _, migration := range applied {
+			log.Info("%s migrated %d: %s", shard, migration.Version, migration.Description)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d shards failed to migrate", failed, len(shards))
+	}
+
+	log.Info("%d shards migrated to schema version %d", len(shards), manager.LatestSchemaVersion())
+	return nil
+}
+
+// List the shard files in a directory and its subdirectories, sorted by path
+func findShards(dir string) ([]string, error) {
+	shards := make([]string, 0)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == manager.DBFileName {
+			shards = append(shards, file)
+		}
+		return nil
+	})
+
+	sort.Strings(shards)
+	return shards, err
+}
+
+// Apply the pending migrations of a shard and refresh its statistics,
+// or only return the pending migrations on dry runs
+func migrateShard(file string, dryRun bool) ([]manager.Migration, error) {
+	db, err := sql.Open("sqlite3", manager.DataSourceName(file))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	pending, err := manager.PendingMigrations(db)
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	if err := manager.Migrate(db); err != nil {
+		return pending, err
+	}
+
+	// Refresh statistics of shards grown since they were gathered
+	return pending, manager.AnalyzeIfStale(db)
+}
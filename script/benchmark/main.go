@@ -0,0 +1,353 @@
+// Benchmark the sharded driver on a synthetic multi-year dataset
+// then the query package on one of its shards, with and without indexes
+//
+// Usage:
+//
+//	go run script/benchmark/main.go -years 2 -rows 50000 -workers 1,2,4,8
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/sqlite"
+	"github.com/GitbookIO/micro-analytics/database/sqlite/manager"
+	"github.com/GitbookIO/micro-analytics/database/sqlite/query"
+)
+
+const dbName = "benchmark"
+
+func main() {
+	years := flag.Int("years", 2, "Number of years of monthly shards to generate")
+	rows := flag.Int("rows", 50000, "Number of analytics per shard")
+	workers := flag.String("workers", "1,4", "Comma separated list of query workers to compare")
+	runs := flag.Int("runs", 3, "Number of runs of each query")
+	flag.Parse()
+
+	directory, err := ioutil.TempDir("", "micro-analytics-benchmark")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(directory)
+
+	// Generate dataset once
+	fmt.Printf("Generating %d shards of %d analytics in %s\n", *years*12, *rows, directory)
+	if err := generate(directory, *years, *rows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := analyze(directory); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Compare each number of workers on the same queries
+	for _, w := range strings.Split(*workers, ",") {
+		queryWorkers, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		driver, err := sqlite.NewShardedDriver(driverOpts(directory, queryWorkers))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("\nWorkers: %d\n", queryWorkers)
+		for _, q := range queries(driver) {
+			start := time.Now()
+			for i := 0; i < *runs; i++ {
+				if err := q.run(); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+			fmt.Printf("  %-14s %v/op\n", q.name, time.Since(start)/time.Duration(*runs))
+		}
+	}
+
+	// Compare the query package on the last full shard with and without indexes
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	shard := path.Join(directory, dbName, month.Format("2006-01"), manager.DBFileName)
+	if err := benchmarkIndexes(shard, month, *runs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func driverOpts(directory string, queryWorkers int) database.DriverOpts {
+	return database.DriverOpts{
+		Directory:      directory,
+		CacheDirectory: directory + "/.cache",
+		MaxDBs:         1000,
+		IdleTimeout:    60,
+		ClosingChannel: make(chan bool, 1),
+		QueryWorkers:   queryWorkers,
+	}
+}
+
+type benchmarkQuery struct {
+	name string
+	run  func() error
+}
+
+func queries(driver *sqlite.Sharded) []benchmarkQuery {
+	params := database.Params{
+		DBName:   dbName,
+		Interval: 24 * 60 * 60,
+		Property: "path",
+		Unique:   true,
+	}
+	params.URL, _ = url.Parse("/" + dbName)
+
+	return []benchmarkQuery{
+		{"Count", func() error {
+			_, err := driver.Count(context.Background(), params)
+			return err
+		}},
+		{"GroupBy", func() error {
+			_, err := driver.GroupBy(context.Background(), params)
+			return err
+		}},
+		{"Series", func() error {
+			_, err := driver.Series(context.Background(), params)
+			return err
+		}},
+	}
+}
+
+// Run shard queries on a copy of a shard without indexes, then on the shard
+func benchmarkIndexes(shard string, month time.Time, runs int) error {
+	noIndexes := path.Join(path.Dir(shard), "no-indexes.db")
+	if err := copyFile(shard, noIndexes); err != nil {
+		return err
+	}
+	defer os.Remove(noIndexes)
+
+	withoutDB, err := sql.Open("sqlite3", noIndexes)
+	if err != nil {
+		return err
+	}
+	defer withoutDB.Close()
+
+	// Keep the unique index on ids, as before indexes were added
+	if err := dropIndexes(withoutDB, "visits_id"); err != nil {
+		return err
+	}
+
+	withDB, err := sql.Open("sqlite3", shard)
+	if err != nil {
+		return err
+	}
+	defer withDB.Close()
+
+	fmt.Printf("\nQuery package on shard %s\n", month.Format("2006-01"))
+	fmt.Printf("  %-24s %14s %14s\n", "", "no indexes", "indexes")
+	for _, q := range shardQueries(month) {
+		without, err := timeShardQuery(withoutDB, q, runs)
+		if err != nil {
+			return err
+		}
+		with, err := timeShardQuery(withDB, q, runs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %-24s %14v %14v\n", q.name, without, with)
+	}
+
+	return nil
+}
+
+type shardQuery struct {
+	name string
+	run  func(db *sql.DB) error
+}
+
+// Queries run by the drivers on each shard, on a week of the month or the whole month
+func shardQueries(month time.Time) []shardQuery {
+	ctx := context.Background()
+	week := &database.TimeRange{
+		Start: month.AddDate(0, 0, 7),
+		End:   month.AddDate(0, 0, 14),
+	}
+	downloads := []database.Filter{{Property: "event", Operator: database.FilterEqual, Values: []string{"download"}}}
+	day := database.SeriesInterval{Seconds: 24 * 60 * 60}
+
+	return []shardQuery{
+		{"Count week", func(db *sql.DB) error {
+			_, err := query.Count(ctx, db, database.UniqueByIp, week, nil)
+			return err
+		}},
+		{"Count month", func(db *sql.DB) error {
+			_, err := query.Count(ctx, db, database.UniqueByIp, nil, nil)
+			return err
+		}},
+		{"Count week event", func(db *sql.DB) error {
+			_, err := query.Count(ctx, db, database.UniqueByIp, week, downloads)
+			return err
+		}},
+		{"GroupBy week path", func(db *sql.DB) error {
+			_, err := query.GroupBy(ctx, db, []string{"path"}, week, nil)
+			return err
+		}},
+		{"GroupBy month path", func(db *sql.DB) error {
+			_, err := query.GroupBy(ctx, db, []string{"path"}, nil, nil)
+			return err
+		}},
+		{"GroupByUniq week path", func(db *sql.DB) error {
+			_, err := query.GroupByUniq(ctx, db, []string{"path"}, database.UniqueByIp, week, nil)
+			return err
+		}},
+		{"GroupByUniq month path", func(db *sql.DB) error {
+			_, err := query.GroupByUniq(ctx, db, []string{"path"}, database.UniqueByIp, nil, nil)
+			return err
+		}},
+		{"Series week", func(db *sql.DB) error {
+			_, err := query.Series(ctx, db, day, week, nil)
+			return err
+		}},
+		{"Series month event", func(db *sql.DB) error {
+			_, err := query.Series(ctx, db, day, nil, downloads)
+			return err
+		}},
+	}
+}
+
+// Return the average duration of a shard query
+func timeShardQuery(db *sql.DB, q shardQuery, runs int) (time.Duration, error) {
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		if err := q.run(db); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start) / time.Duration(runs), nil
+}
+
+// Drop every index of the visits table except some
+func dropIndexes(db *sql.DB, keep ...string) error {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'visits'`)
+	if err != nil {
+		return err
+	}
+
+	indexes := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+
+	for _, index := range indexes {
+		kept := false
+		for _, k := range keep {
+			kept = kept || k == index
+		}
+		if kept {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("DROP INDEX %s", index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Fill one shard per month of the last years with random analytics
+func generate(directory string, years int, rows int) error {
+	driver, err := sqlite.NewShardedDriver(driverOpts(directory, 1))
+	if err != nil {
+		return err
+	}
+
+	events := []string{"download", "login", "view"}
+	platforms := []string{"Linux", "Apple Mac", "Microsoft Windows", "Android"}
+	countries := []string{"fr", "us", "de", "gb", "jp"}
+
+	end := time.Now().UTC()
+	month := time.Date(end.Year()-years, end.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for ; month.Before(end); month = month.AddDate(0, 1, 0) {
+		seconds := int64(month.AddDate(0, 1, 0).Sub(month).Seconds())
+
+		analytics := make([]database.Analytic, 0, rows)
+		for i := 0; i < rows; i++ {
+			analytics = append(analytics, database.Analytic{
+				Time:          month.Add(time.Duration(rand.Int63n(seconds)) * time.Second),
+				Event:         events[rand.Intn(len(events))],
+				Path:          fmt.Sprintf("/page-%d", rand.Intn(200)),
+				Ip:            fmt.Sprintf("10.0.%d.%d", rand.Intn(40), rand.Intn(256)),
+				Platform:      platforms[rand.Intn(len(platforms))],
+				RefererDomain: "gitbook.com",
+				CountryCode:   countries[rand.Intn(len(countries))],
+			})
+		}
+
+		err := driver.BulkInsert(context.Background(), map[string][]database.Analytic{dbName: analytics}, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Refresh statistics of the generated shards, as migrations do when the service starts
+func analyze(directory string) error {
+	shards, err := filepath.Glob(path.Join(directory, dbName, "*", manager.DBFileName))
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		db, err := sql.Open("sqlite3", manager.DataSourceName(shard))
+		if err != nil {
+			return err
+		}
+		err = manager.AnalyzeIfStale(db)
+		db.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
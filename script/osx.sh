@@ -10,4 +10,4 @@ DIR=$( cd "$( dirname "${BASH_SOURCE[0]}" )" && cd .. && pwd )
 # Change to current dir
 cd ${DIR}
 
-go build -ldflags "-s" -o "${DIR}/script/build/micro-analytics_darwin_amd64"
+go build -tags json1 -ldflags "-s" -o "${DIR}/script/build/micro-analytics_darwin_amd64"
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/oschwald/maxminddb-golang"
 
 	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/buffer"
 	"github.com/GitbookIO/micro-analytics/web"
 )
 
@@ -18,6 +21,12 @@ type ServerOpts struct {
 	DriverOpts     database.DriverOpts
 	Geolite2Reader *maxminddb.Reader
 	Auth           *web.BasicAuth
+	QueryTimeout   time.Duration
+	IngestBuffer   buffer.Opts
+	// Headers set by trusted proxies to the IP of the client
+	TrustedProxyHeaders []string
+	// Addresses of the trusted proxies, skipped when reading their headers
+	TrustedProxies []*net.IPNet
 }
 
 // Build a http.Server based on the options
@@ -36,6 +45,12 @@ func NewServer(opts ServerOpts) (*http.Server, error) {
 		DriverOpts:     opts.DriverOpts,
 		Geolite2Reader: opts.Geolite2Reader,
 		Version:        opts.Version,
+		QueryTimeout:   opts.QueryTimeout,
+		IngestBuffer:   opts.IngestBuffer,
+		Auth:           opts.Auth,
+
+		TrustedProxyHeaders: opts.TrustedProxyHeaders,
+		TrustedProxies:      opts.TrustedProxies,
 	}
 
 	handler, err := web.NewRouter(routerOpts)
@@ -43,11 +58,6 @@ func NewServer(opts ServerOpts) (*http.Server, error) {
 		return nil, err
 	}
 
-	// Use authentication if username provided
-	if len(opts.Auth.Name) > 0 {
-		handler = web.BasicAuthMiddleware(opts.Auth, handler)
-	}
-
 	// Attach to main router
 	r.PathPrefix("/").Handler(handler)
 
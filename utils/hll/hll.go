@@ -0,0 +1,227 @@
+package hll
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// Number of bits of a hash used to select a register
+// Estimations have a standard error of 1.04/sqrt(registers), about 1.6%,
+// and a dense Sketch takes 4KB in results and cache
+const precision = 12
+
+// Number of registers of a dense Sketch
+const registers = 1 << precision
+
+// Max number of hashes kept by a sparse Sketch
+// A sparse Sketch uses as much memory as a dense one at this size
+const maxSparse = registers / 8
+
+// Binary formats of a Sketch
+const (
+	formatSparse byte = 0
+	formatDense  byte = 1
+)
+
+// Sketch is a mergeable HyperLogLog cardinality estimator
+// It keeps the exact set of hashes until it grows too large,
+// then switches to registers and only estimates its cardinality
+type Sketch struct {
+	sparse map[uint64]bool
+	dense  []uint8
+}
+
+// Get a new empty Sketch
+func New() *Sketch {
+	return &Sketch{
+		sparse: make(map[uint64]bool),
+	}
+}
+
+// Add a value to the Sketch
+func (s *Sketch) Add(value string) {
+	s.addHash(hash(value))
+}
+
+// Merge another Sketch into s
+func (s *Sketch) Merge(other *Sketch) {
+	if other.dense != nil {
+		s.toDense()
+		for i, rho := range other.dense {
+			if rho > s.dense[i] {
+				s.dense[i] = rho
+			}
+		}
+		return
+	}
+
+	for h := range other.sparse {
+		s.addHash(h)
+	}
+}
+
+// Return true if Count is the exact cardinality
+func (s *Sketch) Exact() bool {
+	return s.dense == nil
+}
+
+// Return the cardinality of the Sketch
+func (s *Sketch) Count() int {
+	if s.dense == nil {
+		return len(s.sparse)
+	}
+
+	m := float64(registers)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, rho := range s.dense {
+		sum += math.Pow(2, -float64(rho))
+		if rho == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha * m * m / sum
+
+	// Use linear counting for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(estimate + 0.5)
+}
+
+// Encode the Sketch in binary format
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	if s.dense != nil {
+		return append([]byte{formatDense}, s.dense...), nil
+	}
+
+	// Sort hashes to produce a stable encoding
+	hashes := make([]uint64, 0, len(s.sparse))
+	for h := range s.sparse {
+		hashes = append(hashes, h)
+	}
+	sort.Sort(uint64List(hashes))
+
+	data := make([]byte, 1+8*len(hashes))
+	data[0] = formatSparse
+	for i, h := range hashes {
+		binary.BigEndian.PutUint64(data[1+8*i:], h)
+	}
+	return data, nil
+}
+
+// Decode a Sketch from binary format
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("hll: empty sketch data")
+	}
+
+	switch data[0] {
+	case formatDense:
+		if len(data) != 1+registers {
+			return errors.New("hll: invalid dense sketch size")
+		}
+		s.sparse = nil
+		s.dense = append([]uint8{}, data[1:]...)
+	case formatSparse:
+		if (len(data)-1)%8 != 0 {
+			return errors.New("hll: invalid sparse sketch size")
+		}
+		s.dense = nil
+		s.sparse = make(map[uint64]bool)
+		for i := 1; i < len(data); i += 8 {
+			s.sparse[binary.BigEndian.Uint64(data[i:])] = true
+		}
+	default:
+		return errors.New("hll: unknown sketch format")
+	}
+
+	return nil
+}
+
+// Encode the Sketch as a base64 JSON string
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+// Decode a Sketch from a base64 JSON string
+func (s *Sketch) UnmarshalJSON(b []byte) error {
+	var data []byte
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	return s.UnmarshalBinary(data)
+}
+
+func (s *Sketch) addHash(h uint64) {
+	if s.dense == nil {
+		s.sparse[h] = true
+		if len(s.sparse) > maxSparse {
+			s.toDense()
+		}
+		return
+	}
+
+	// Use the first bits as register index
+	// and the position of the leftmost 1 in the other bits as value
+	index := h >> (64 - precision)
+	rho := uint8(1)
+	for w := h << precision; w&(1<<63) == 0 && rho <= 64-precision; w <<= 1 {
+		rho++
+	}
+
+	if rho > s.dense[index] {
+		s.dense[index] = rho
+	}
+}
+
+// Switch a sparse Sketch to registers
+func (s *Sketch) toDense() {
+	if s.dense != nil {
+		return
+	}
+
+	s.dense = make([]uint8, registers)
+	for h := range s.sparse {
+		s.addHash(h)
+	}
+	s.sparse = nil
+}
+
+// Hash a value using FNV-1a and a final mix for better bits distribution
+func hash(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	h := hasher.Sum64()
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Define an alias of []uint64 to implement sort
+type uint64List []uint64
+
+func (l uint64List) Len() int {
+	return len(l)
+}
+func (l uint64List) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+func (l uint64List) Less(i, j int) bool {
+	return l[i] < l[j]
+}
@@ -0,0 +1,157 @@
+package hll
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Build a Sketch of the values from start to end excluded
+func sketchOf(start int, end int) *Sketch {
+	s := New()
+	for i := start; i < end; i++ {
+		s.Add(fmt.Sprintf("visitor-%d", i))
+	}
+	return s
+}
+
+// Check that count is within 3 standard errors of want
+func checkEstimate(t *testing.T, name string, count int, want int) {
+	tolerance := 3 * 1.04 / math.Sqrt(registers)
+	if math.Abs(float64(count-want)) > tolerance*float64(want) {
+		t.Errorf("%s: count %d, want %d within %.1f%%", name, count, want, 100*tolerance)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		exact bool
+	}{
+		{"empty", 0, true},
+		{"single", 1, true},
+		{"small", 100, true},
+		{"largest sparse", maxSparse, true},
+		{"smallest dense", maxSparse + 1, false},
+		{"dense", 10000, false},
+		{"large", 200000, false},
+	}
+
+	for _, test := range tests {
+		s := sketchOf(0, test.n)
+		if s.Exact() != test.exact {
+			t.Errorf("%s: exact %v, want %v", test.name, s.Exact(), test.exact)
+		}
+		if test.exact && s.Count() != test.n {
+			t.Errorf("%s: count %d, want %d", test.name, s.Count(), test.n)
+		}
+		checkEstimate(t, test.name, s.Count(), test.n)
+	}
+}
+
+func TestAddDuplicates(t *testing.T) {
+	s := New()
+	for i := 0; i < 3*maxSparse; i++ {
+		s.Add("visitor")
+	}
+	if !s.Exact() || s.Count() != 1 {
+		t.Errorf("count %d exact %v, want exact count 1", s.Count(), s.Exact())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name         string
+		aStart, aEnd int
+		bStart, bEnd int
+		union        int
+		exact        bool
+	}{
+		{"sparse disjoint", 0, 100, 100, 200, 200, true},
+		{"sparse overlapping", 0, 100, 50, 150, 150, true},
+		{"sparse into dense when merged", 0, 300, 300, 600, 600, false},
+		{"sparse into dense", 0, 100, 0, 5000, 5000, false},
+		{"dense into sparse", 0, 5000, 4900, 5100, 5100, false},
+		{"dense overlapping", 0, 20000, 10000, 30000, 30000, false},
+		{"empty", 0, 0, 0, 100, 100, true},
+	}
+
+	for _, test := range tests {
+		a := sketchOf(test.aStart, test.aEnd)
+		a.Merge(sketchOf(test.bStart, test.bEnd))
+		if a.Exact() != test.exact {
+			t.Errorf("%s: exact %v, want %v", test.name, a.Exact(), test.exact)
+		}
+		if test.exact && a.Count() != test.union {
+			t.Errorf("%s: count %d, want %d", test.name, a.Count(), test.union)
+		}
+		checkEstimate(t, test.name, a.Count(), test.union)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		size int
+	}{
+		{"empty", 0, 1},
+		{"sparse", 10, 1 + 8*10},
+		{"largest sparse", maxSparse, 1 + 8*maxSparse},
+		{"dense", maxSparse + 1, 1 + registers},
+	}
+
+	for _, test := range tests {
+		s := sketchOf(0, test.n)
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(data) != test.size {
+			t.Errorf("%s: size %d, want %d", test.name, len(data), test.size)
+		}
+
+		decoded := &Sketch{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if decoded.Count() != s.Count() || decoded.Exact() != s.Exact() {
+			t.Errorf("%s: decoded count %d exact %v, want %d %v", test.name, decoded.Count(), decoded.Exact(), s.Count(), s.Exact())
+		}
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"unknown format", []byte{2}},
+		{"truncated sparse", []byte{formatSparse, 1, 2, 3}},
+		{"truncated dense", append([]byte{formatDense}, make([]byte, registers-1)...)},
+	}
+
+	for _, test := range tests {
+		if err := (&Sketch{}).UnmarshalBinary(test.data); err == nil {
+			t.Errorf("%s: decoded invalid data", test.name)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	s := sketchOf(0, 42)
+	data, err := s.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &Sketch{}
+	if err := decoded.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != 42 {
+		t.Errorf("decoded count %d, want 42", decoded.Count())
+	}
+}
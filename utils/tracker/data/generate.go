@@ -0,0 +1,3 @@
+package trackerjs
+
+//go:generate go-bindata -o trackerjs.go -ignore=\.go$ -ignore=\.DS_Store -pkg=trackerjs ./...
@@ -0,0 +1,98 @@
+/*! µAnalytics tracker v1.0.0 */
+(function (window, document) {
+    'use strict';
+
+    // Find the script tag loading the tracker
+    var script = document.currentScript;
+    if (!script) {
+        var scripts = document.getElementsByTagName('script');
+        for (var i = scripts.length - 1; i >= 0; i--) {
+            if (/\/tracker\.js(\?|$)/.test(scripts[i].src)) {
+                script = scripts[i];
+                break;
+            }
+        }
+    }
+    if (!script) {
+        return;
+    }
+
+    // Analytics are sent to the routes of the website serving the script
+    var base = script.src.replace(/\/tracker\.js(\?.*)?$/, '');
+    var auto = script.getAttribute('data-auto') !== 'false';
+
+    // Page the visitor came from, only sent with the first page view
+    // since following page views and events are made on the website itself
+    var referrer = document.referrer;
+    var lastPath = null;
+
+    // Send an analytic, recorded for the current page if path is not provided
+    function send(event, path, referrer) {
+        var query = 'event=' + encodeURIComponent(event) +
+            '&path=' + encodeURIComponent(path || window.location.pathname) +
+            '&referrer=' + encodeURIComponent(referrer);
+
+        if (navigator.sendBeacon && navigator.sendBeacon(base + '/beacon?' + query)) {
+            return;
+        }
+        new Image(1, 1).src = base + '/pixel.gif?' + query;
+    }
+
+    // Send a custom event
+    function track(event, path) {
+        send(event, path, '');
+    }
+
+    // Record a page view if the path changed
+    function pageview() {
+        var path = window.location.pathname;
+        if (path === lastPath) {
+            return;
+        }
+
+        send('pageview', path, referrer);
+        lastPath = path;
+        referrer = '';
+    }
+
+    // Record page views of single page apps navigating with the history API
+    function watchHistory() {
+        var history = window.history;
+        if (!history || !history.pushState) {
+            return;
+        }
+
+        ['pushState', 'replaceState'].forEach(function (name) {
+            var original = history[name];
+            history[name] = function () {
+                var result = original.apply(this, arguments);
+                pageview();
+                return result;
+            };
+        });
+        window.addEventListener('popstate', pageview);
+    }
+
+    // Calls queued before loading: window.microAnalytics = [['track', 'signup']]
+    var queue = window.microAnalytics;
+
+    window.microAnalytics = {
+        version: '1.0.0',
+        track: track,
+        pageview: pageview
+    };
+
+    if (queue && queue.length) {
+        for (var j = 0; j < queue.length; j++) {
+            var call = queue[j];
+            if (call && typeof window.microAnalytics[call[0]] === 'function') {
+                window.microAnalytics[call[0]].apply(null, Array.prototype.slice.call(call, 1));
+            }
+        }
+    }
+
+    if (auto) {
+        pageview();
+        watchHistory();
+    }
+})(window, document);
@@ -0,0 +1,235 @@
+// Code generated by go-bindata.
+// sources:
+// tracker.js
+// DO NOT EDIT!
+
+package trackerjs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func bindataRead(data []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("Read %q: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, gz)
+	clErr := gz.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("Read %q: %v", name, err)
+	}
+	if clErr != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type asset struct {
+	bytes []byte
+	info  os.FileInfo
+}
+
+type bindataFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi bindataFileInfo) Name() string {
+	return fi.name
+}
+func (fi bindataFileInfo) Size() int64 {
+	return fi.size
+}
+func (fi bindataFileInfo) Mode() os.FileMode {
+	return fi.mode
+}
+func (fi bindataFileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+func (fi bindataFileInfo) IsDir() bool {
+	return false
+}
+func (fi bindataFileInfo) Sys() interface{} {
+	return nil
+}
+
+var _trackerJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x95\x56\xdd\x6e\xdb\x36\x14\xbe\xf7\x53\x30\x40\x11\x4a\x89\x23\x27\xb7\xf1\xbc\x20\x2d\x32\x2c\xc0\x30\x04\x4d\x77\xe5\xf8\x82\xa6\x68\x99\x29\x4d\x6a\x24\x65\xcf\x68\xf2\x58\x7b\x81\x3d\x59\x0f\x29\x91\x92\x2c\xbb\xc5\x0c\x03\x96\xa9\x8f\xe7\xe7\x3b\xdf\x39\xe4\xe4\xe2\x0c\xfd\xf7\xef\xbd\x24\x62\x6f\x39\x35\xc8\x6a\x42\xbf\x32\x8d\xb6\x37\xd9\x75\x76\x8d\x2e\x26\xa3\x64\x55\x49\x6a\xb9\x92\x28\xd9\x71\x99\xab\xdd\x18\xe5\x8a\x56\x1b\x26\x6d\x8a\xbe\x8d\x10\x7c\x70\x65\x18\x32\x56\x73\x6a\xf1\x74\xe4\x97\x26\x13\xf4\x1b\xa0\x91\x5d\xc3\x1b\xaa\x79\x69\x91\x25\x05\x12\x8a\xe4\x5c\x16\x7e\xb9\x71\xe5\xe1\x5b\xa2\x03\x6c\x16\xcd\x67\xb4\xd2\x1a\x7e\x9f\xfd\x8b\xa9\x07\xf2\x15\x4a\xce\x6a\x64\xf0\xde\xdf\x6f\xba\x06\x0a\x66\x1f\x04\x73\x8f\xe6\xe3\xfe\x0b\x29\xfe\x24\x1b\x96\xe0\x1a\x88\xd3\x69\xdc\xbe\x52\x1a\x25\xce\x06\x87\xdd\x8d\x9d\x4c\x30\x59\xd8\x35\xba\x42\x37\x53\x58\xff\x75\x86\xae\xe1\xf7\xea\xaa\xeb\x36\x44\x34\x79\x99\x34\xd9\xbc\x64\xaf\x26\x79\xb9\x7b\xfb\x90\x4e\x32\xcb\x8c\x4d\x1a\x6b\x73\xbe\xc8\x8c\xa6\xe9\xe1\x6e\xf7\x89\x89\xb7\xd8\xe9\x00\xb4\xd4\x8c\x7c\xed\x2f\xbf\x8f\xfa\x4f\xef\x3f\x62\x48\x33\x5b\x69\x39\x6d\x80\xa1\x46\x6d\xe1\x89\x86\x42\x01\x51\xc8\x2a\x5f\x1d\xad\x2a\x88\x1f\xa9\x95\xff\xb7\x63\x4b\xc3\xad\x43\xe8\x6d\xa8\x5f\xed\x23\x96\x6f\x49\x40\x04\x21\x07\x97\x6b\xa6\x59\x29\x08\x65\x43\x76\xb2\x8b\xf4\xee\xc3\x64\x8c\x70\xa8\x81\xdb\x4f\x2a\xf0\x1c\xf7\x43\xe5\xee\x2d\x28\x6a\x09\x51\x24\x38\x27\x96\x5c\x39\x00\x4e\xd1\xd9\x6c\x86\xf0\x8a\x08\xc3\x3a\x5a\x7b\x22\x05\xf3\x41\x6d\x39\xc4\x09\xd5\xa4\x50\x69\xb4\xd2\x6a\x33\x46\x4a\x8a\x7d\x9d\xda\x8e\x43\x3d\x1d\x6a\xc5\xb5\xb1\xa8\x74\x9b\xb6\x9c\xed\x82\x15\xc3\x25\x85\x97\x4a\x08\xb5\x73\x59\x46\x00\xd0\x03\x5a\x66\x5b\x27\x24\xcf\xd4\x86\xe4\x0c\x0c\xf7\xb8\xe1\xd6\x30\xb1\x8a\xf9\x68\xb6\x62\x20\x5f\xdd\xd5\x63\x58\x6b\xb3\x16\xc4\xd8\x27\x02\x61\xcd\x90\xac\x84\x68\x33\x7a\x66\xe0\x91\x48\xf8\xd6\x15\x1a\x83\x41\xaa\x74\xce\x72\xaf\x56\xe7\xb8\x69\x8f\x3a\x4c\x28\x7b\xe9\xec\x70\x83\xa4\x82\x35\xad\xb6\x1c\xc0\xde\x5c\xec\x60\x60\x21\x4f\x7c\x1a\x63\x8f\x1e\xc7\x28\x0f\x9b\xe9\xef\x8a\xe9\x3d\x04\x85\x3d\x7a\x86\xd1\x25\x62\x92\xaa\x9c\xfd\xf5\xf9\xf1\x93\xda\x94\x4a\xc2\x72\x6d\x2a\x45\x97\x3d\x59\xe2\x73\x67\xfa\xd4\x16\x1f\xe4\xdb\x1b\xaa\x67\x49\x26\x14\x25\x2e\xb4\xcc\xad\x4b\x28\xda\xd0\x5a\x08\xf1\x94\xc5\x98\x42\x43\x5e\x68\x02\x49\xb6\xbc\x20\x20\x86\xcc\xa5\xfd\x91\x11\x0a\x0c\x9c\x9f\xa3\x63\xeb\x89\x57\xef\x25\xc2\x93\xa5\xff\x7f\xe7\x7c\x79\x0e\x06\x1d\xdb\x6d\xa4\x7e\x17\x4a\xb6\x43\x8f\x1b\x28\x46\x72\x33\x46\x37\xa9\xeb\x01\x60\x30\x5a\x2e\xf9\x3f\x4c\x64\x05\x5f\xb5\xc6\x0f\xdb\xb1\x2e\x3a\x14\xd6\x58\xb5\xa9\xf5\xd6\x2f\xa0\xef\xa3\x6e\x05\xbb\xd1\x0d\xab\x1b\x1b\xac\x75\xf1\xd9\xab\x08\x9c\x44\x71\x3b\xb2\x9c\x9c\x7c\x65\xe8\x9a\xc8\xe2\x50\x36\x0e\xea\x90\xc9\xa1\x4a\xca\x5a\xb9\xa7\x6a\x39\xed\xd5\xa3\x06\x43\xf7\x06\xcd\xff\x9c\xd9\x7e\x6a\x38\xc4\x81\x07\xea\x6d\x37\x75\x1a\xca\x61\xa6\x9d\x01\x18\xfb\x11\xe3\x13\xac\x74\x1a\x1e\x06\x1f\x8c\x83\x42\xb0\x7a\x91\x94\xa5\x09\xca\x71\xa3\x21\x8e\x92\x35\x87\x5a\x41\xab\xdc\x3f\x3d\xf6\x49\xdb\x11\x4b\xd7\xbf\xd7\x6f\x07\xc4\x85\x5d\x91\xbb\x66\xa1\xcf\xd8\x59\x80\x41\xbf\x84\xe7\xac\xac\xcc\xfa\xd9\x12\xcb\xfe\x07\x7d\x73\x1c\x77\x01\x77\xb8\x19\xcc\xf5\xff\x45\x06\xf3\xe4\x81\xd0\x75\xe7\xa0\xaf\x1b\xb1\x6f\xde\x85\xad\x34\x2f\x38\x0c\x24\x88\xbb\x09\x67\xee\xa0\x07\xc7\x55\xef\x15\x40\x5b\xbb\xc7\x4e\xbf\x7a\x56\x9a\x4a\xb8\x13\x30\x38\xc8\x80\x6f\xb1\x4f\x2c\x98\x1a\xc3\xc0\x2d\xfc\xf8\x34\xe9\xf0\x58\x6c\xa5\x39\x7c\x57\x13\xd2\x18\x3f\x38\x3a\x3b\x34\x75\x76\x36\xc5\x20\x79\xfe\xe0\xda\xe8\x0f\x48\x84\x49\xa6\x41\x79\xaa\x34\x0d\x7b\xc1\xe3\xa0\xb3\x3e\x11\x21\x8c\xeb\xeb\x0a\x66\xf4\x92\x01\xab\x2c\xdc\x77\x6e\x83\xe5\x0d\xa7\x5a\xb5\x87\xee\x0c\xcd\xe7\xd8\xf7\xb4\x2b\x8b\xe1\x85\xac\x4a\xbc\x58\x8c\x3a\x53\xb8\x62\xad\x48\xfa\xbb\x9b\x71\x77\xca\x72\x47\x70\x4c\x1b\xa0\xff\x16\x61\x7f\xa9\xc3\xe3\xf8\xc6\xbb\xbe\xad\x7f\xda\xd5\x90\xe1\x6d\x7c\x1a\x35\x9c\xc5\x0b\x46\x1d\x18\x0c\x53\xff\xd0\x5c\x95\xba\xd5\x8d\x37\xaa\x57\xe4\x2f\x4e\xaf\xe8\x97\x1e\x16\x56\x2e\x2f\x8f\x49\x8c\x02\x8b\xb0\xc5\x63\xe7\xaf\x07\xca\x72\xae\x3d\x00\x3c\xdb\x7d\xc9\xa0\x49\x8f\xa6\x3f\x77\xa0\xf9\xf5\x62\xe1\xe7\x0d\x0e\x02\xc4\xc7\xf4\xf7\x63\x03\x8d\x10\xdd\xd9\x3c\x46\xf7\x5a\x13\x68\x40\xad\xac\x72\xde\x33\x23\x38\x65\x99\x83\xfa\xa8\xdc\xd0\x4f\x7f\x76\x45\x8b\x14\xba\xeb\x4c\x37\x9e\x63\x4a\xee\xcf\x90\x20\xb8\xf7\x74\x78\x13\x9f\x8e\xbe\x03\x6c\xe7\xd9\xb7\xca\x0b\x00\x00")
+
+func trackerJsBytes() ([]byte, error) {
+	return bindataRead(
+		_trackerJs,
+		"tracker.js",
+	)
+}
+
+func trackerJs() (*asset, error) {
+	bytes, err := trackerJsBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "tracker.js", size: 3018, mode: os.FileMode(420), modTime: time.Unix(1792214574, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+// Asset loads and returns the asset for the given name.
+// It returns an error if the asset could not be found or
+// could not be loaded.
+func Asset(name string) ([]byte, error) {
+	cannonicalName := strings.Replace(name, "\\", "/", -1)
+	if f, ok := _bindata[cannonicalName]; ok {
+		a, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("Asset %s can't read by error: %v", name, err)
+		}
+		return a.bytes, nil
+	}
+	return nil, fmt.Errorf("Asset %s not found", name)
+}
+
+// MustAsset is like Asset but panics when Asset would return an error.
+// It simplifies safe initialization of global variables.
+func MustAsset(name string) []byte {
+	a, err := Asset(name)
+	if err != nil {
+		panic("asset: Asset(" + name + "): " + err.Error())
+	}
+
+	return a
+}
+
+// AssetInfo loads and returns the asset info for the given name.
+// It returns an error if the asset could not be found or
+// could not be loaded.
+func AssetInfo(name string) (os.FileInfo, error) {
+	cannonicalName := strings.Replace(name, "\\", "/", -1)
+	if f, ok := _bindata[cannonicalName]; ok {
+		a, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("AssetInfo %s can't read by error: %v", name, err)
+		}
+		return a.info, nil
+	}
+	return nil, fmt.Errorf("AssetInfo %s not found", name)
+}
+
+// AssetNames returns the names of the assets.
+func AssetNames() []string {
+	names := make([]string, 0, len(_bindata))
+	for name := range _bindata {
+		names = append(names, name)
+	}
+	return names
+}
+
+// _bindata is a table, holding each asset generator, mapped to its name.
+var _bindata = map[string]func() (*asset, error){
+	"tracker.js": trackerJs,
+}
+
+// AssetDir returns the file names below a certain
+// directory embedded in the file by go-bindata.
+// For example if you run go-bindata on data/... and data contains the
+// following hierarchy:
+//     data/
+//       foo.txt
+//       img/
+//         a.png
+//         b.png
+// then AssetDir("data") would return []string{"foo.txt", "img"}
+// AssetDir("data/img") would return []string{"a.png", "b.png"}
+// AssetDir("foo.txt") and AssetDir("notexist") would return an error
+// AssetDir("") will return []string{"data"}.
+func AssetDir(name string) ([]string, error) {
+	node := _bintree
+	if len(name) != 0 {
+		cannonicalName := strings.Replace(name, "\\", "/", -1)
+		pathList := strings.Split(cannonicalName, "/")
+		for _, p := range pathList {
+			node = node.Children[p]
+			if node == nil {
+				return nil, fmt.Errorf("Asset %s not found", name)
+			}
+		}
+	}
+	if node.Func != nil {
+		return nil, fmt.Errorf("Asset %s not found", name)
+	}
+	rv := make([]string, 0, len(node.Children))
+	for childName := range node.Children {
+		rv = append(rv, childName)
+	}
+	return rv, nil
+}
+
+type bintree struct {
+	Func     func() (*asset, error)
+	Children map[string]*bintree
+}
+
+var _bintree = &bintree{nil, map[string]*bintree{
+	"tracker.js": &bintree{trackerJs, map[string]*bintree{}},
+}}
+
+// RestoreAsset restores an asset under the given directory
+func RestoreAsset(dir, name string) error {
+	data, err := Asset(name)
+	if err != nil {
+		return err
+	}
+	info, err := AssetInfo(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(_filePath(dir, filepath.Dir(name)), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(_filePath(dir, name), data, info.Mode())
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(_filePath(dir, name), info.ModTime(), info.ModTime())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RestoreAssets restores an asset under the given directory recursively
+func RestoreAssets(dir, name string) error {
+	children, err := AssetDir(name)
+	// File
+	if err != nil {
+		return RestoreAsset(dir, name)
+	}
+	// Dir
+	for _, child := range children {
+		err = RestoreAssets(dir, filepath.Join(name, child))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func _filePath(dir, name string) string {
+	cannonicalName := strings.Replace(name, "\\", "/", -1)
+	return filepath.Join(append([]string{dir}, strings.Split(cannonicalName, "/")...)...)
+}
@@ -0,0 +1,42 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/azer/logger"
+
+	"github.com/GitbookIO/micro-analytics/utils/tracker/data"
+)
+
+// Version of tracker.js, to bump along with the version in the script
+const Version = "1.0.0"
+
+type Script struct {
+	Content []byte
+	// Subresource Integrity hash of the content
+	Integrity string
+	// Entity tag of the content
+	ETag string
+}
+
+func GetTrackerScript() (*Script, error) {
+	var log = logger.New("[Tracker]")
+
+	content, err := trackerjs.Asset("tracker.js")
+	if err != nil {
+		log.Error("Unable to open tracker.js asset file: [%v]", err)
+		return nil, err
+	}
+
+	integrity := sha512.Sum384(content)
+	etag := sha256.Sum256(content)
+
+	return &Script{
+		Content:   content,
+		Integrity: "sha384-" + base64.StdEncoding.EncodeToString(integrity[:]),
+		ETag:      `"` + hex.EncodeToString(etag[:8]) + `"`,
+	}, nil
+}
@@ -0,0 +1,184 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/azer/logger"
+	"github.com/oschwald/maxminddb-golang"
+
+	webErrors "github.com/GitbookIO/micro-analytics/web/errors"
+	. "github.com/GitbookIO/micro-analytics/web/structures"
+
+	"github.com/GitbookIO/micro-analytics/database"
+	driverErrors "github.com/GitbookIO/micro-analytics/database/errors"
+)
+
+// Header making retries of a bulk request safe
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Maximum length of an idempotency key
+const maxIdempotencyKeyLength = 255
+
+// Give analytics without id an id derived from the idempotency key of their request
+// Retrying a request with the same key then inserts each analytic only once
+func setIdempotencyIds(list []PostAnalytic, key string) error {
+	if key == "" {
+		return nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return &webErrors.InvalidIdempotencyKey
+	}
+
+	for i := range list {
+		if list[i].Id == "" {
+			list[i].Id = idempotencyId(key, i)
+		}
+	}
+
+	return nil
+}
+
+// Return the id of the analytic at index in a request with an idempotency key
+func idempotencyId(key string, index int) string {
+	return key + "/" + strconv.Itoa(index)
+}
+
+// Analytics of a bulk request grouped by DB, along with their index in the request
+type bulkInsert struct {
+	analytics map[string][]database.Analytic
+	indexes   map[string][]int
+	result    BulkResult
+}
+
+// Parse analytics of a bulk request, rejecting invalid ones
+// Analytics are inserted in dbName if not empty, in their website otherwise
+func newBulkInsert(list []PostAnalytic, dbName string, geolite2 *maxminddb.Reader, log *logger.Logger) *bulkInsert {
+	bulk := &bulkInsert{
+		analytics: make(map[string][]database.Analytic),
+		indexes:   make(map[string][]int),
+		result: BulkResult{
+			Rejected: []BulkRejection{},
+		},
+	}
+
+	for i, postData := range list {
+		website := dbName
+		if website == "" {
+			website = postData.Website
+		}
+
+		// Reject analytic if website parameter missing
+		if website == "" {
+			bulk.reject(i, &webErrors.MissingWebsite)
+			continue
+		}
+
+		// Parse data
+		analytic, err := ParseAnalytic(postData, geolite2, log)
+		if err != nil {
+			bulk.reject(i, err)
+			continue
+		}
+
+		bulk.analytics[website] = append(bulk.analytics[website], analytic)
+		bulk.indexes[website] = append(bulk.indexes[website], i)
+	}
+
+	return bulk
+}
+
+func (bulk *bulkInsert) reject(index int, err error) {
+	rejection := BulkRejection{
+		Index:   index,
+		Reason:  webErrors.InternalError.Code,
+		Message: err.Error(),
+	}
+	if rqError, ok := err.(*webErrors.RequestError); ok {
+		rejection.Reason = rqError.Code
+	}
+
+	bulk.result.Rejected = append(bulk.result.Rejected, rejection)
+}
+
+// Insert parsed analytics and count accepted ones
+// Analytics of failing shards are rejected, along with the error to render the result with
+// If atomic, all analytics are rejected if any shard fails
+func (bulk *bulkInsert) insert(ctx context.Context, driver database.Driver, atomic bool) error {
+	total := 0
+	for _, analytics := range bulk.analytics {
+		total += len(analytics)
+	}
+
+	err := driver.BulkInsert(ctx, bulk.analytics, atomic)
+	if err == nil {
+		bulk.result.Accepted = total
+		return nil
+	}
+
+	// Reject all analytics if the driver doesn't tell failed ones
+	bulkErr, ok := err.(*driverErrors.BulkInsertError)
+	if !ok {
+		bulkErr = &driverErrors.BulkInsertError{}
+		if driverErr, ok := err.(*driverErrors.DriverError); ok {
+			bulkErr.DriverError = *driverErr
+		} else {
+			bulkErr.DriverError = driverErrors.InternalError
+		}
+		for dbName, indexes := range bulk.indexes {
+			positions := make([]int, len(indexes))
+			for i := range positions {
+				positions[i] = i
+			}
+			bulkErr.Fail(dbName, positions)
+		}
+	}
+
+	normalizedErr := normalizeDriverError(&bulkErr.DriverError)
+	for dbName, positions := range bulkErr.Failed {
+		for _, position := range positions {
+			bulk.reject(bulk.indexes[dbName][position], normalizedErr)
+		}
+		total -= len(positions)
+	}
+	sort.Sort(rejectionsByIndex(bulk.result.Rejected))
+
+	bulk.result.Accepted = total
+	return normalizedErr
+}
+
+// Sort rejections in the order of the request
+type rejectionsByIndex []BulkRejection
+
+func (r rejectionsByIndex) Len() int           { return len(r) }
+func (r rejectionsByIndex) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rejectionsByIndex) Less(i, j int) bool { return r[i].Index < r[j].Index }
+
+// Response of a bulk request failing for some analytics
+type bulkErrorResponse struct {
+	*webErrors.RequestError
+	BulkResult
+}
+
+// Render the result of a bulk request
+// The status code and error fields of err are used if provided
+func renderBulkResult(w http.ResponseWriter, result BulkResult, err error) {
+	if err == nil {
+		render(w, result, nil)
+		return
+	}
+
+	rqError, ok := err.(*webErrors.RequestError)
+	if !ok {
+		renderError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rqError.StatusCode())
+	if err := jsonMarshal(w, bulkErrorResponse{rqError, result}); err != nil {
+		genericError(w, err)
+	}
+}
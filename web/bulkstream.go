@@ -0,0 +1,130 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/azer/logger"
+	"github.com/oschwald/maxminddb-golang"
+
+	webErrors "github.com/GitbookIO/micro-analytics/web/errors"
+	. "github.com/GitbookIO/micro-analytics/web/structures"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Number of analytics of a stream inserted together
+const streamBatchSize = 1000
+
+// Maximum length of a line of a stream
+const maxStreamLineLength = 1024 * 1024
+
+// Insert analytics of a NDJSON request body by batches, as lines are read
+// Analytics are inserted in dbName if not empty, in their website otherwise
+// Rejected analytics are indexed by their line in the body, starting from 0
+func insertStream(req *http.Request, driver database.Driver, dbName string, geolite2 *maxminddb.Reader, log *logger.Logger) (BulkResult, error) {
+	result := BulkResult{
+		Rejected: []BulkRejection{},
+	}
+
+	key := req.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		return result, &webErrors.InvalidIdempotencyKey
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return result, err
+	}
+	defer body.Close()
+
+	// Current batch and the line of each of its analytics
+	batch := make([]PostAnalytic, 0, streamBatchSize)
+	lines := make([]int, 0, streamBatchSize)
+
+	var insertErr error
+	insertBatch := func() {
+		bulk := newBulkInsert(batch, dbName, geolite2, log)
+		if err := bulk.insert(req.Context(), driver, false); err != nil {
+			insertErr = err
+		}
+
+		result.Accepted += bulk.result.Accepted
+		for _, rejection := range bulk.result.Rejected {
+			rejection.Index = lines[rejection.Index]
+			result.Rejected = append(result.Rejected, rejection)
+		}
+
+		batch = batch[:0]
+		lines = lines[:0]
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineLength)
+	for line := 0; scanner.Scan(); line++ {
+		// Stop reading if client disconnected
+		if req.Context().Err() != nil {
+			return result, &webErrors.QueryCanceled
+		}
+
+		// Skip empty lines
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		postData := PostAnalytic{}
+		if err := json.Unmarshal(scanner.Bytes(), &postData); err != nil {
+			result.Rejected = append(result.Rejected, BulkRejection{
+				Index:   line,
+				Reason:  webErrors.InvalidJSON.Code,
+				Message: webErrors.InvalidJSON.Message,
+			})
+			continue
+		}
+
+		// Derive missing id from the idempotency key
+		if key != "" && postData.Id == "" {
+			postData.Id = idempotencyId(key, line)
+		}
+
+		batch = append(batch, postData)
+		lines = append(lines, line)
+		if len(batch) == streamBatchSize {
+			insertBatch()
+		}
+	}
+
+	// Insert analytics read before the end or an error
+	if len(batch) > 0 {
+		insertBatch()
+	}
+	sort.Sort(rejectionsByIndex(result.Rejected))
+
+	if err := scanner.Err(); err != nil {
+		log.Error("Error reading stream: %v", err)
+		return result, &webErrors.InvalidStream
+	}
+
+	return result, insertErr
+}
+
+// Return the body of a request, decompressed according to its Content-Encoding
+func requestBody(req *http.Request) (io.ReadCloser, error) {
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return req.Body, nil
+	case "gzip":
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, &webErrors.InvalidStream
+		}
+		return reader, nil
+	}
+
+	return nil, &webErrors.UnsupportedEncoding
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	. "github.com/GitbookIO/micro-analytics/web/structures"
+
+	"github.com/GitbookIO/micro-analytics/utils"
+	"github.com/GitbookIO/micro-analytics/utils/tracker"
+)
+
+// Transparent 1x1 GIF returned by the tracking pixel
+var pixelGIF, _ = base64.StdEncoding.DecodeString("R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7")
+
+// Cache durations of the JavaScript tracker, in seconds
+// Requests with the current version as v parameter can be cached forever
+const (
+	trackerMaxAge          = 3600
+	trackerVersionedMaxAge = 31536000
+)
+
+// Serve the JavaScript tracker along with its integrity hash
+func serveTrackerScript(w http.ResponseWriter, req *http.Request, script *tracker.Script) {
+	maxAge := trackerMaxAge
+	if req.URL.Query().Get("v") == tracker.Version {
+		maxAge = trackerVersionedMaxAge
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	w.Header().Set("ETag", script.ETag)
+	w.Header().Set("X-Integrity", script.Integrity)
+	w.Header().Set("X-Tracker-Version", tracker.Version)
+	// Browsers check integrity of cross-origin scripts with CORS only
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if req.Header.Get("If-None-Match") == script.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(script.Content)
+}
+
+// Build the analytic sent by a visitor's browser
+// event, path, referrer, userId and sessionId are read from the request query or form,
+// path and referrer default to the page sending the request
+func collectedAnalytic(req *http.Request, trustedProxyHeaders []string, trustedProxies []*net.IPNet) PostAnalytic {
+	postData := PostAnalytic{
+		Id:        req.Form.Get("id"),
+		Event:     req.Form.Get("event"),
+		Path:      req.Form.Get("path"),
+		Ip:        clientIp(req, trustedProxyHeaders, trustedProxies),
+		UserId:    req.Form.Get("userId"),
+		SessionId: req.Form.Get("sessionId"),
+		Headers: map[string]string{
+			"User-Agent": req.UserAgent(),
+			"Referer":    req.Referer(),
+		},
+	}
+
+	// Referer header is the page sending the request
+	if postData.Path == "" {
+		if pageURL, err := url.ParseRequestURI(req.Referer()); err == nil {
+			postData.Path = pageURL.Path
+		}
+	}
+
+	// Referer header is the page itself, not the page it was reached from
+	// which can only be known by the browser and takes precedence if passed,
+	// even if empty for direct visits
+	if _, ok := req.Form["referrer"]; ok {
+		postData.Headers["Referer"] = req.Form.Get("referrer")
+	}
+
+	return postData
+}
+
+// Return the IP address of the client of a request
+// Trusted proxy headers are only read if the request comes from a trusted proxy.
+// Proxies append the address of their client to these headers,
+// so they are read from the right and the first address which isn't a trusted
+// proxy is used, addresses on its left being set by the client itself
+func clientIp(req *http.Request, trustedProxyHeaders []string, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	remoteIp := net.ParseIP(host)
+	if remoteIp == nil || !trustedProxy(remoteIp, trustedProxies) {
+		return host
+	}
+
+	for _, header := range trustedProxyHeaders {
+		// Proxies may also add the header on a new line
+		lines := req.Header[http.CanonicalHeaderKey(header)]
+		values := strings.Split(strings.Join(lines, ","), ",")
+		for i := len(values) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(values[i]))
+			if ip == nil {
+				break
+			}
+			if !trustedProxy(ip, trustedProxies) {
+				return ip.String()
+			}
+		}
+	}
+
+	return host
+}
+
+// Check whether an IP address belongs to a trusted proxy
+func trustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether analytics can be collected for a website
+// Collection routes are public and only insert into existing websites
+func collectedWebsite(directory string, dbName string) (bool, error) {
+	if dbName != path.Base(dbName) || dbName == "." || dbName == ".." {
+		return false, nil
+	}
+	return utils.PathExists(path.Join(directory, dbName))
+}
+
+// Parse comma-separated IP addresses and CIDR ranges of trusted proxies
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0)
+	for _, value := range strings.Split(s, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+
+		// Single addresses are ranges of a single address
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %s", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Return the params of the query to compare results with, if asked in form
+// The shifted start and end are set in the URL to cache results separately
+func comparedParams(form url.Values, params database.Params) (*database.Params, *database.TimeShift, error) {
+	compare := form.Get("compare")
+	if len(compare) == 0 {
+		return nil, nil, nil
+	}
+
+	now := time.Now().UTC()
+	shift, err := database.NewTimeShift(compare, params.TimeRange, params.SeriesInterval(), now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := params
+	previous.TimeRange = shift.TimeRange(params.TimeRange, now)
+
+	// Compare with every result, unfilled
+	previous.Fill = database.FillNone
+	previous.Limit = 0
+	previous.Offset = 0
+
+	// Set shifted time range in URL
+	query := params.URL.Query()
+	query.Del("compare")
+	query.Set("start", previous.TimeRange.Start.Format(time.RFC3339))
+	query.Set("end", previous.TimeRange.End.Format(time.RFC3339))
+
+	previousURL := *params.URL
+	previousURL.RawQuery = query.Encode()
+	previous.URL = &previousURL
+
+	return &previous, shift, nil
+}
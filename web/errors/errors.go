@@ -20,7 +20,13 @@ var InvalidDatabaseName = RequestError{
 
 var InvalidInterval = RequestError{
 	Code:       "InvalidInterval",
-	Message:    "Invalid interval format in request query. Please use specify a number in seconds and retry.",
+	Message:    "Invalid interval format in request query. Please specify a number in seconds or one of day, week, month, quarter or year and retry.",
+	statusCode: 405,
+}
+
+var InvalidTimezone = RequestError{
+	Code:       "InvalidTimezone",
+	Message:    "Invalid timezone in request query. Please use a name of the IANA Time Zone database, e.g. Europe/Paris, and retry.",
 	statusCode: 405,
 }
 
@@ -41,3 +47,147 @@ var InvalidTimeFormat = RequestError{
 	Message:    "Invalid time format in request query. Please use RFC3339 time and retry.",
 	statusCode: 405,
 }
+
+var InvalidFilter = RequestError{
+	Code:       "InvalidFilter",
+	Message:    "Invalid filter in request query. Please check and retry.",
+	statusCode: 405,
+}
+
+var InvalidPagination = RequestError{
+	Code:       "InvalidPagination",
+	Message:    "Invalid limit or offset in request query. Please use positive integers and retry.",
+	statusCode: 405,
+}
+
+var InvalidSort = RequestError{
+	Code:       "InvalidSort",
+	Message:    "Invalid sort in request query. Please use one of total, unique or label and retry.",
+	statusCode: 405,
+}
+
+var InvalidFormat = RequestError{
+	Code:       "InvalidFormat",
+	Message:    "Invalid format in request query. Please use one of json, ndjson or csv and retry.",
+	statusCode: 405,
+}
+
+var InvalidOrder = RequestError{
+	Code:       "InvalidOrder",
+	Message:    "Invalid order in request query. Please use one of asc or desc and retry.",
+	statusCode: 405,
+}
+
+var InvalidCursor = RequestError{
+	Code:       "InvalidCursor",
+	Message:    "Invalid cursor in request query. Please use a cursor returned by a previous request and retry.",
+	statusCode: 405,
+}
+
+var InvalidFill = RequestError{
+	Code:       "InvalidFill",
+	Message:    "Invalid fill in request query. Please use one of zero, null or none and retry.",
+	statusCode: 405,
+}
+
+var InvalidUniqueBy = RequestError{
+	Code:       "InvalidUniqueBy",
+	Message:    "Invalid uniqueBy in request query. Please use one of ip, user or session and retry.",
+	statusCode: 405,
+}
+
+var InvalidSessionTimeout = RequestError{
+	Code:       "InvalidSessionTimeout",
+	Message:    "Invalid timeout in request query. Please use a positive number of seconds and retry.",
+	statusCode: 405,
+}
+
+var InvalidFunnel = RequestError{
+	Code:       "InvalidFunnel",
+	Message:    "Invalid funnel in request body. Please specify 2 to 10 steps with an event or a path, and a positive window in seconds, and retry.",
+	statusCode: 400,
+}
+
+var TooManyIntervals = RequestError{
+	Code:       "TooManyIntervals",
+	Message:    "Too many intervals to fill in time series. Please use a larger interval or a shorter time range and retry.",
+	statusCode: 405,
+}
+
+var InvalidCompare = RequestError{
+	Code:       "InvalidCompare",
+	Message:    "Invalid compare in request query. Please use one of previous or year along with a start time and retry.",
+	statusCode: 405,
+}
+
+var QueryTimeout = RequestError{
+	Code:       "QueryTimeout",
+	Message:    "Your query took too long to complete. Please reduce its time range and retry.",
+	statusCode: 504,
+}
+
+var QueryCanceled = RequestError{
+	Code:       "QueryCanceled",
+	Message:    "Your query was canceled before completion.",
+	statusCode: 400,
+}
+
+var MissingWebsite = RequestError{
+	Code:       "MissingWebsite",
+	Message:    "Missing website in analytic. Please set its website and retry.",
+	statusCode: 400,
+}
+
+var InvalidAnalyticTime = RequestError{
+	Code:       "InvalidAnalyticTime",
+	Message:    "Invalid time in analytic. Please use an RFC3339 or RFC1123 time or a Unix timestamp and retry.",
+	statusCode: 400,
+}
+
+var InvalidAnalyticIp = RequestError{
+	Code:       "InvalidAnalyticIp",
+	Message:    "Invalid IP address in analytic. Please check and retry.",
+	statusCode: 400,
+}
+
+var BulkRejected = RequestError{
+	Code:       "BulkRejected",
+	Message:    "Some analytics are invalid, none was inserted. Please fix the rejected analytics and retry.",
+	statusCode: 400,
+}
+
+var AtomicNotSupported = RequestError{
+	Code:       "AtomicNotSupported",
+	Message:    "Atomic inserts are not supported while inserts are buffered. Please retry without atomic.",
+	statusCode: 400,
+}
+
+var InvalidIdempotencyKey = RequestError{
+	Code:       "InvalidIdempotencyKey",
+	Message:    "Invalid Idempotency-Key header. Please use a key of at most 255 characters and retry.",
+	statusCode: 400,
+}
+
+var InvalidStream = RequestError{
+	Code:       "InvalidStream",
+	Message:    "Invalid stream in request body. Please send newline-delimited JSON with lines of at most 1MB and retry.",
+	statusCode: 400,
+}
+
+var UnsupportedEncoding = RequestError{
+	Code:       "UnsupportedEncoding",
+	Message:    "Unsupported Content-Encoding in request. Please send an uncompressed or gzip-compressed body and retry.",
+	statusCode: 415,
+}
+
+var UnknownWebsite = RequestError{
+	Code:       "UnknownWebsite",
+	Message:    "Website doesn't exist. Please insert its first analytics with an authenticated request and retry.",
+	statusCode: 404,
+}
+
+var InvalidForm = RequestError{
+	Code:       "InvalidForm",
+	Message:    "Invalid form in request query or body. Please check and retry.",
+	statusCode: 400,
+}
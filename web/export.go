@@ -0,0 +1,176 @@
+package web
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Formats of a raw query response
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// Map streaming formats w/ their Content-Type
+var exportContentTypes = map[string]string{
+	formatNDJSON: "application/x-ndjson",
+	formatCSV:    "text/csv; charset=utf-8",
+}
+
+// Columns of a CSV export, in order
+// Named as the fields of the import command so that exports can be imported back
+var exportCSVHeader = []string{"time", "event", "path", "ip", "platform", "refererDomain", "countryCode", "id", "userId", "sessionId", "properties"}
+
+// Number of rows written between two flushes of a stream
+const exportFlushRows = 1000
+
+// Return the format asked for a raw query
+// format in the request query takes precedence over the Accept header
+func parseExportFormat(req *http.Request) (string, bool) {
+	if format := req.Form.Get("format"); format != "" {
+		switch format {
+		case formatJSON, formatNDJSON, formatCSV:
+			return format, true
+		}
+		return "", false
+	}
+
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return formatNDJSON, true
+	case strings.Contains(accept, "text/csv"):
+		return formatCSV, true
+	}
+
+	return formatJSON, true
+}
+
+// Write analytics to a streaming response as they are read from the driver
+// Headers are only sent along with the first row so that errors occurring
+// before can still be rendered as JSON
+type exportWriter struct {
+	w       http.ResponseWriter
+	format  string
+	started bool
+	rows    int
+
+	encoder   *json.Encoder
+	csvWriter *csv.Writer
+}
+
+func newExportWriter(w http.ResponseWriter, format string) *exportWriter {
+	return &exportWriter{
+		w:      w,
+		format: format,
+	}
+}
+
+// Send headers and the CSV header row
+func (e *exportWriter) start() error {
+	e.started = true
+	e.w.Header().Set("Content-Type", exportContentTypes[e.format])
+
+	switch e.format {
+	case formatNDJSON:
+		e.encoder = json.NewEncoder(e.w)
+	case formatCSV:
+		e.csvWriter = csv.NewWriter(e.w)
+		return e.csvWriter.Write(exportCSVHeader)
+	}
+
+	return nil
+}
+
+// Write a single analytic
+func (e *exportWriter) Write(analytic database.Analytic) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch e.format {
+	case formatNDJSON:
+		err = e.encoder.Encode(analytic)
+	case formatCSV:
+		var properties string
+		properties, err = csvProperties(analytic.Properties)
+		if err != nil {
+			return err
+		}
+		err = e.csvWriter.Write([]string{
+			analytic.Time.Format(time.RFC3339),
+			analytic.Event,
+			analytic.Path,
+			analytic.Ip,
+			analytic.Platform,
+			analytic.RefererDomain,
+			analytic.CountryCode,
+			analytic.Id,
+			analytic.UserId,
+			analytic.SessionId,
+			properties,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Flush regularly to send chunks to the client
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.Flush()
+	}
+
+	return nil
+}
+
+// Send buffered rows to the client
+func (e *exportWriter) Flush() error {
+	if e.csvWriter != nil {
+		e.csvWriter.Flush()
+		if err := e.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// Complete the response, even if no analytic was written
+func (e *exportWriter) Close() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	return e.Flush()
+}
+
+// Return true if rows have already been sent to the client
+func (e *exportWriter) Started() bool {
+	return e.started
+}
+
+// Encode custom properties as a JSON object in a CSV cell
+// Analytics without properties have an empty cell
+func csvProperties(properties map[string]interface{}) (string, error) {
+	if len(properties) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(properties)
+	return string(data), err
+}
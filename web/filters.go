@@ -0,0 +1,83 @@
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/GitbookIO/micro-analytics/database"
+)
+
+// Map allowed filters w/ columns names in DB schema
+var filterProperties = map[string]string{
+	"event":         "event",
+	"path":          "path",
+	"platform":      "platform",
+	"countryCode":   "countryCode",
+	"refererDomain": "refererDomain",
+}
+
+// Map query string key suffixes w/ filter operators
+// path^=/docs/ is parsed by url.ParseQuery as key "path^" and value "/docs/"
+var filterOperators = map[string]string{
+	"!": database.FilterNotEqual,
+	"^": database.FilterPrefix,
+}
+
+// Extract filters from a parsed request query
+// Multiple values for the same key match any of them
+func newFilters(form url.Values) ([]database.Filter, error) {
+	// Sort keys to produce filters in a stable order
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filters := make([]database.Filter, 0)
+	for _, key := range keys {
+		name := key
+		operator := database.FilterEqual
+
+		// Detect operator from key suffix
+		for suffix, op := range filterOperators {
+			if strings.HasSuffix(key, suffix) {
+				name = strings.TrimSuffix(key, suffix)
+				operator = op
+			}
+		}
+
+		property, ok := filterProperty(name)
+		if !ok {
+			if strings.HasPrefix(name, database.CustomPropertyPrefix) {
+				return nil, fmt.Errorf("Invalid custom property '%s'", name)
+			}
+			// Ignore other query parameters but reject unknown filters
+			if operator != database.FilterEqual {
+				return nil, fmt.Errorf("Unknown filter property '%s'", name)
+			}
+			continue
+		}
+
+		filters = append(filters, database.Filter{
+			Property: property,
+			Operator: operator,
+			Values:   form[key],
+		})
+	}
+
+	return filters, nil
+}
+
+// Return the DB property of a filter name
+// Custom properties are filtered as properties.<key>
+func filterProperty(name string) (string, bool) {
+	if property, ok := filterProperties[name]; ok {
+		return property, true
+	}
+	if _, ok := database.CustomPropertyKey(name); ok {
+		return name, true
+	}
+	return "", false
+}
@@ -1,8 +1,11 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -18,17 +21,60 @@ import (
 	. "github.com/GitbookIO/micro-analytics/web/structures"
 
 	"github.com/GitbookIO/micro-analytics/database"
+	"github.com/GitbookIO/micro-analytics/database/buffer"
 	driverErrors "github.com/GitbookIO/micro-analytics/database/errors"
 	"github.com/GitbookIO/micro-analytics/database/sqlite"
 
 	"github.com/GitbookIO/micro-analytics/utils"
 	"github.com/GitbookIO/micro-analytics/utils/geoip"
+	"github.com/GitbookIO/micro-analytics/utils/tracker"
 )
 
+// Map allowed requests w/ columns names in DB schema
+var allowedProperties = map[string]string{
+	"countries": "countryCode",
+	"platforms": "platform",
+	"domains":   "refererDomain",
+	"events":    "event",
+	"paths":     "path",
+}
+
+// Map columns allowed in /by/{column} w/ columns names in DB schema
+var allowedColumns = map[string]string{
+	"event":         "event",
+	"path":          "path",
+	"ip":            "ip",
+	"platform":      "platform",
+	"refererDomain": "refererDomain",
+	"countryCode":   "countryCode",
+}
+
+// Orders allowed for aggregations
+var allowedSorts = map[string]bool{
+	database.SortByTotal:  true,
+	database.SortByUnique: true,
+	database.SortByLabel:  true,
+}
+
+// Identifiers of visitors allowed to count unique visitors
+var allowedUniqueBy = map[string]bool{
+	database.UniqueByIp:      true,
+	database.UniqueByUser:    true,
+	database.UniqueBySession: true,
+}
+
 type RouterOpts struct {
 	DriverOpts     database.DriverOpts
 	Geolite2Reader *maxminddb.Reader
 	Version        string
+	QueryTimeout   time.Duration
+	IngestBuffer   buffer.Opts
+	// Required by all routes but collection ones if Name is set
+	Auth *BasicAuth
+	// Headers set by trusted proxies to the IP of the client
+	TrustedProxyHeaders []string
+	// Addresses of the trusted proxies, skipped when reading their headers
+	TrustedProxies []*net.IPNet
 }
 
 func NewRouter(opts RouterOpts) (http.Handler, error) {
@@ -39,11 +85,26 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 	geolite2 := opts.Geolite2Reader
 
 	// Initiate DB driver
+	var driver database.Driver
 	driver, err := sqlite.NewShardedDriver(opts.DriverOpts)
 	if err != nil {
 		return nil, err
 	}
 
+	// Load the JavaScript tracker
+	trackerScript, err := tracker.GetTrackerScript()
+	if err != nil {
+		return nil, err
+	}
+
+	// Buffer inserts if asked
+	if len(opts.IngestBuffer.Directory) > 0 {
+		driver, err = buffer.New(driver, opts.IngestBuffer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	/////
 	// Query a DB over time
 	/////
@@ -73,37 +134,104 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				return
 			}
 
-			// Cast interval to an integer
+			// Get filters if provided
+			filters, err := newFilters(req.Form)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFilter)
+				return
+			}
+
+			// Cast interval to an integer, or use a calendar interval
 			// Defaults to 1 day
 			interval := 24 * 60 * 60
-			if len(intervalStr) > 0 {
+			calendarInterval := ""
+			if database.CalendarIntervals[intervalStr] {
+				calendarInterval = intervalStr
+			} else if len(intervalStr) > 0 {
 				interval, err = strconv.Atoi(intervalStr)
-				if err != nil {
+				if err != nil || interval <= 0 {
 					renderError(w, &webErrors.InvalidInterval)
 					return
 				}
 			}
 
+			// Get fill if provided
+			// Defaults to none
+			fill := req.Form.Get("fill")
+			switch fill {
+			case "":
+				fill = database.FillNone
+			case database.FillNone, database.FillZero, database.FillNull:
+			default:
+				renderError(w, &webErrors.InvalidFill)
+				return
+			}
+
+			// Get timezone if provided
+			// Defaults to UTC
+			location := time.UTC
+			if tz := req.Form.Get("tz"); len(tz) > 0 {
+				location, err = time.LoadLocation(tz)
+				if err != nil {
+					renderError(w, &webErrors.InvalidTimezone)
+					return
+				}
+			}
+
 			unique := false
 			if strings.Compare(req.Form.Get("unique"), "true") == 0 {
 				unique = true
 			}
 
+			// Get identifier of unique visitors if provided
+			uniqueBy, ok := parseUniqueBy(req.Form.Get("uniqueBy"))
+			if !ok {
+				renderError(w, &webErrors.InvalidUniqueBy)
+				return
+			}
+			if len(req.Form.Get("uniqueBy")) > 0 {
+				unique = true
+			}
+
 			// Construct Params object
 			params := database.Params{
-				DBName:    dbName,
-				Interval:  interval,
-				TimeRange: timeRange,
-				Unique:    unique,
-				URL:       req.URL,
+				CalendarInterval: calendarInterval,
+				DBName:           dbName,
+				Fill:             fill,
+				Filters:          filters,
+				Interval:         interval,
+				Location:         location,
+				TimeRange:        timeRange,
+				Unique:           unique,
+				UniqueBy:         uniqueBy,
+				URL:              req.URL,
+			}
+
+			// Get compared query if asked
+			previousParams, shift, err := comparedParams(req.Form, params)
+			if err != nil {
+				renderError(w, &webErrors.InvalidCompare)
+				return
 			}
 
-			analytics, err := driver.Series(params)
+			ctx, cancel := queryContext(req, opts.QueryTimeout)
+			defer cancel()
+
+			analytics, err := driver.Series(ctx, params)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
 			}
 
+			if previousParams != nil {
+				previous, err := driver.Series(ctx, *previousParams)
+				if err != nil {
+					renderError(w, normalizeDriverError(err))
+					return
+				}
+				analytics.Compare(*previous, params.SeriesInterval(), *shift)
+			}
+
 			// Return query result
 			render(w, analytics, nil)
 		})
@@ -136,55 +264,213 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				return
 			}
 
+			// Get filters if provided
+			filters, err := newFilters(req.Form)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFilter)
+				return
+			}
+
 			unique := false
 			if strings.Compare(req.Form.Get("unique"), "true") == 0 {
 				unique = true
 			}
 
+			// Get identifier of unique visitors if provided
+			uniqueBy, ok := parseUniqueBy(req.Form.Get("uniqueBy"))
+			if !ok {
+				renderError(w, &webErrors.InvalidUniqueBy)
+				return
+			}
+
 			// Construct Params object
 			params := database.Params{
 				DBName:    dbName,
+				Filters:   filters,
 				TimeRange: timeRange,
 				Unique:    unique,
+				UniqueBy:  uniqueBy,
 				URL:       req.URL,
 			}
 
-			analytics, err := driver.Count(params)
+			// Get compared query if asked
+			previousParams, _, err := comparedParams(req.Form, params)
+			if err != nil {
+				renderError(w, &webErrors.InvalidCompare)
+				return
+			}
+
+			ctx, cancel := queryContext(req, opts.QueryTimeout)
+			defer cancel()
+
+			analytics, err := driver.Count(ctx, params)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
 			}
 
+			if previousParams != nil {
+				previous, err := driver.Count(ctx, *previousParams)
+				if err != nil {
+					renderError(w, normalizeDriverError(err))
+					return
+				}
+				analytics.Compare(*previous)
+			}
+
 			// Return query result
 			render(w, analytics, nil)
 		})
 
 	/////
-	// Query a DB by property
+	// Query a DB grouped by a column
 	/////
-	r.Path("/{dbName}/{property}").
+	groupBy := func(w http.ResponseWriter, req *http.Request, dbName string, properties []string) {
+		// Parse request query
+		if err := req.ParseForm(); err != nil {
+			renderError(w, err)
+			return
+		}
+
+		// Get timeRange if provided
+		startTime := req.Form.Get("start")
+		endTime := req.Form.Get("end")
+
+		timeRange, err := newTimeRange(startTime, endTime)
+		if err != nil {
+			renderError(w, &webErrors.InvalidTimeFormat)
+			return
+		}
+
+		// Get filters if provided
+		filters, err := newFilters(req.Form)
+		if err != nil {
+			renderError(w, &webErrors.InvalidFilter)
+			return
+		}
+
+		// Get limit and offset if provided
+		limit, offset, err := parsePagination(req.Form.Get("limit"), req.Form.Get("offset"))
+		if err != nil {
+			renderError(w, &webErrors.InvalidPagination)
+			return
+		}
+
+		// Get sort order if provided
+		// Defaults to total
+		sortBy := req.Form.Get("sort")
+		if len(sortBy) == 0 {
+			sortBy = database.SortByTotal
+		}
+		if _, ok := allowedSorts[sortBy]; !ok {
+			renderError(w, &webErrors.InvalidSort)
+			return
+		}
+
+		// Get identifier of unique visitors if provided
+		uniqueBy, ok := parseUniqueBy(req.Form.Get("uniqueBy"))
+		if !ok {
+			renderError(w, &webErrors.InvalidUniqueBy)
+			return
+		}
+
+		// Sorting by unique or choosing visitors requires unique counts
+		unique := false
+		if strings.Compare(req.Form.Get("unique"), "true") == 0 || sortBy == database.SortByUnique || len(req.Form.Get("uniqueBy")) > 0 {
+			unique = true
+		}
+
+		// Construct Params object
+		params := database.Params{
+			DBName:     dbName,
+			Filters:    filters,
+			Limit:      limit,
+			Offset:     offset,
+			Properties: properties,
+			Sort:       sortBy,
+			TimeRange:  timeRange,
+			Unique:     unique,
+			UniqueBy:   uniqueBy,
+			URL:        req.URL,
+		}
+
+		// Get compared query if asked
+		previousParams, _, err := comparedParams(req.Form, params)
+		if err != nil {
+			renderError(w, &webErrors.InvalidCompare)
+			return
+		}
+
+		ctx, cancel := queryContext(req, opts.QueryTimeout)
+		defer cancel()
+
+		analytics, err := driver.GroupBy(ctx, params)
+		if err != nil {
+			renderError(w, normalizeDriverError(err))
+			return
+		}
+
+		if previousParams != nil {
+			previous, err := driver.GroupBy(ctx, *previousParams)
+			if err != nil {
+				renderError(w, normalizeDriverError(err))
+				return
+			}
+			analytics.Compare(*previous)
+		}
+
+		// Return query result
+		render(w, analytics, nil)
+	}
+
+	r.Path("/{dbName}/by/{column}").
 		Methods("GET").
 		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
-			// Map allowed requests w/ columns names in DB schema
-			allowedProperties := map[string]string{
-				"countries": "countryCode",
-				"platforms": "platform",
-				"domains":   "refererDomain",
-				"events":    "event",
-			}
 			// Get params from URL
 			vars := mux.Vars(req)
 			dbName := vars["dbName"]
-			property := vars["property"]
+			column := vars["column"]
 
-			// Check that property is allowed to be queried
-			property, ok := allowedProperties[property]
+			// Check that column is allowed to be grouped by
+			property, ok := groupByProperty(column)
 			if !ok {
 				renderError(w, &webErrors.InvalidProperty)
 				return
 			}
 
+			groupBy(w, req, dbName, []string{property})
+		})
+
+	r.Path("/{dbName}/group").
+		Methods("GET").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get params from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
+			// Check that every column is allowed to be grouped by
+			properties, err := parseGroupByColumns(req.FormValue("by"))
+			if err != nil {
+				renderError(w, &webErrors.InvalidProperty)
+				return
+			}
+
+			groupBy(w, req, dbName, properties)
+		})
+
+	/////
+	// Query sessions of visitors
+	/////
+	r.Path("/{dbName}/sessions").
+		Methods("GET").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get params from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
 			// Parse request query
 			if err := req.ParseForm(); err != nil {
 				renderError(w, err)
@@ -195,34 +481,168 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 			startTime := req.Form.Get("start")
 			endTime := req.Form.Get("end")
 
+			// Convert startTime and endTime to a TimeRange
 			timeRange, err := newTimeRange(startTime, endTime)
 			if err != nil {
 				renderError(w, &webErrors.InvalidTimeFormat)
 				return
 			}
 
-			unique := false
-			if strings.Compare(req.Form.Get("unique"), "true") == 0 {
-				unique = true
+			// Get filters if provided
+			filters, err := newFilters(req.Form)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFilter)
+				return
+			}
+
+			// Get identifier of visitors if provided
+			uniqueBy, ok := parseUniqueBy(req.Form.Get("uniqueBy"))
+			if !ok {
+				renderError(w, &webErrors.InvalidUniqueBy)
+				return
+			}
+
+			// Get inactivity timeout if provided
+			timeout, err := parseSessionTimeout(req.Form.Get("timeout"))
+			if err != nil {
+				renderError(w, &webErrors.InvalidSessionTimeout)
+				return
+			}
+
+			// Get number of entry and exit pages if provided
+			limit := database.DefaultSessionPagesLimit
+			if limitStr := req.Form.Get("limit"); len(limitStr) > 0 {
+				limit, _, err = parsePagination(limitStr, "")
+				if err != nil {
+					renderError(w, &webErrors.InvalidPagination)
+					return
+				}
 			}
 
 			// Construct Params object
 			params := database.Params{
-				DBName:    dbName,
-				Property:  property,
-				TimeRange: timeRange,
-				Unique:    unique,
-				URL:       req.URL,
+				DBName:         dbName,
+				Filters:        filters,
+				TimeRange:      timeRange,
+				UniqueBy:       uniqueBy,
+				SessionTimeout: timeout,
+				Limit:          limit,
+				URL:            req.URL,
 			}
 
-			analytics, err := driver.GroupBy(params)
+			ctx, cancel := queryContext(req, opts.QueryTimeout)
+			defer cancel()
+
+			sessions, err := driver.Sessions(ctx, params)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
 			}
 
 			// Return query result
-			render(w, analytics, nil)
+			render(w, sessions, nil)
+		})
+
+	/////
+	// Query a funnel of steps
+	/////
+	r.Path("/{dbName}/funnel").
+		Methods("POST").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get params from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
+			// Parse JSON POST data
+			postFunnel := PostFunnel{}
+			jsonDecoder := json.NewDecoder(req.Body)
+			if err := jsonDecoder.Decode(&postFunnel); err != nil {
+				renderError(w, &webErrors.InvalidJSON)
+				return
+			}
+
+			steps, window, err := parseFunnel(postFunnel)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFunnel)
+				return
+			}
+
+			// Parse request query
+			if err := req.ParseForm(); err != nil {
+				renderError(w, err)
+				return
+			}
+
+			// Get timeRange if provided
+			startTime := req.Form.Get("start")
+			endTime := req.Form.Get("end")
+
+			// Convert startTime and endTime to a TimeRange
+			timeRange, err := newTimeRange(startTime, endTime)
+			if err != nil {
+				renderError(w, &webErrors.InvalidTimeFormat)
+				return
+			}
+
+			// Get filters if provided
+			filters, err := newFilters(req.Form)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFilter)
+				return
+			}
+
+			// Get identifier of visitors if provided
+			uniqueBy, ok := parseUniqueBy(req.Form.Get("uniqueBy"))
+			if !ok {
+				renderError(w, &webErrors.InvalidUniqueBy)
+				return
+			}
+
+			// Construct Params object
+			params := database.Params{
+				DBName:       dbName,
+				Filters:      filters,
+				FunnelSteps:  steps,
+				FunnelWindow: window,
+				TimeRange:    timeRange,
+				UniqueBy:     uniqueBy,
+				URL:          req.URL,
+			}
+
+			ctx, cancel := queryContext(req, opts.QueryTimeout)
+			defer cancel()
+
+			funnel, err := driver.Funnel(ctx, params)
+			if err != nil {
+				renderError(w, normalizeDriverError(err))
+				return
+			}
+
+			// Return query result
+			render(w, funnel, nil)
+		})
+
+	/////
+	// Query a DB by property
+	/////
+	r.Path("/{dbName}/{property}").
+		Methods("GET").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get params from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+			property := vars["property"]
+
+			// Check that property is allowed to be queried
+			property, ok := allowedProperties[property]
+			if !ok {
+				renderError(w, &webErrors.InvalidProperty)
+				return
+			}
+
+			groupBy(w, req, dbName, []string{property})
 		})
 
 	/////
@@ -252,14 +672,79 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				return
 			}
 
+			// Get filters if provided
+			filters, err := newFilters(req.Form)
+			if err != nil {
+				renderError(w, &webErrors.InvalidFilter)
+				return
+			}
+
+			// Get response format
+			format, ok := parseExportFormat(req)
+			if !ok {
+				renderError(w, &webErrors.InvalidFormat)
+				return
+			}
+
+			// Get order if provided
+			order := req.Form.Get("order")
+			if order != "" && order != database.OrderAsc && order != database.OrderDesc {
+				renderError(w, &webErrors.InvalidOrder)
+				return
+			}
+
+			// Get page size and position if provided
+			limit, _, err := parsePagination(req.Form.Get("limit"), "")
+			if err != nil {
+				renderError(w, &webErrors.InvalidPagination)
+				return
+			}
+
+			var cursor *database.Cursor
+			if cursorStr := req.Form.Get("cursor"); cursorStr != "" {
+				cursor, err = database.DecodeCursor(cursorStr)
+				if err != nil {
+					renderError(w, &webErrors.InvalidCursor)
+					return
+				}
+			}
+
 			// Construct Params object
 			params := database.Params{
+				Cursor:    cursor,
 				DBName:    dbName,
+				Filters:   filters,
+				Limit:     limit,
+				Order:     order,
 				TimeRange: timeRange,
 				URL:       req.URL,
 			}
 
-			analytics, err := driver.Query(params)
+			// Stream exports without loading them in memory
+			// Exports can be long, so only stop if the client goes away
+			if format != formatJSON {
+				export := newExportWriter(w, format)
+				err := driver.Stream(req.Context(), params, export.Write)
+				if err != nil {
+					// Response is already partially sent
+					if export.Started() {
+						log.Error("Error streaming export of DB %s: %v", dbName, err)
+						return
+					}
+					renderError(w, normalizeDriverError(err))
+					return
+				}
+
+				if err := export.Close(); err != nil {
+					log.Error("Error streaming export of DB %s: %v", dbName, err)
+				}
+				return
+			}
+
+			ctx, cancel := queryContext(req, opts.QueryTimeout)
+			defer cancel()
+
+			analytics, err := driver.Query(ctx, params)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
@@ -288,33 +773,54 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				return
 			}
 
-			// Group analytics by website
-			analytics := make(map[string][]database.Analytic)
-
-			for _, postData := range postList.List {
-				// Skip analytic if website parameter missing
-				if postData.Website == "" {
-					log.Error("Skipping analytic: website parameter missing on POST data")
-					continue
-				}
+			// Derive missing ids from the idempotency key
+			if err := setIdempotencyIds(postList.List, req.Header.Get(idempotencyKeyHeader)); err != nil {
+				renderError(w, err)
+				return
+			}
 
-				// Parse data
-				analytic := parseAnalytic(postData, geolite2, log)
+			// Parse analytics, grouped by website
+			bulk := newBulkInsert(postList.List, "", geolite2, log)
 
-				// Add to list
-				analytics[postData.Website] = append(analytics[postData.Website], analytic)
+			// Reject the whole list if any analytic is invalid in strict or atomic mode
+			atomic := req.URL.Query().Get("atomic") == "true"
+			strict := req.URL.Query().Get("strict") == "true" || atomic
+			if strict && len(bulk.result.Rejected) > 0 {
+				renderBulkResult(w, bulk.result, &webErrors.BulkRejected)
+				return
 			}
 
 			// Insert
-			err = driver.BulkInsert(analytics)
+			err = bulk.insert(req.Context(), driver, atomic)
 			if err != nil {
-				renderError(w, normalizeDriverError(err))
+				log.Error("Failed to insert analytics: %#v", bulk.result.Rejected)
+				renderBulkResult(w, bulk.result, err)
 				return
 			}
 
-			log.Info("Successfully inserted analytics: %#v", analytics)
+			log.Info("Successfully inserted analytics: %#v", bulk.analytics)
 
-			render(w, nil, nil)
+			renderBulkResult(w, bulk.result, nil)
+		})
+
+	/////
+	// Push a stream of analytics to different DBs
+	/////
+	r.Path("/bulk/stream").
+		Methods("POST").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Insert analytics as they are read
+			result, err := insertStream(req, driver, "", geolite2, log)
+			if err != nil {
+				log.Error("Failed to insert stream of analytics: %v", err)
+				renderBulkResult(w, result, err)
+				return
+			}
+
+			log.Info("Successfully inserted %d analytics from stream", result.Accepted)
+
+			renderBulkResult(w, result, nil)
 		})
 
 	/////
@@ -343,10 +849,15 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 
 			// Create Analytic to inject in DB
 			analytic := database.Analytic{
+				Id:    postData.Id,
 				Time:  time.Now(),
 				Event: postData.Event,
 				Path:  postData.Path,
 				Ip:    postData.Ip,
+
+				UserId:     postData.UserId,
+				SessionId:  postData.SessionId,
+				Properties: postData.Properties,
 			}
 
 			// Set time from POST data if passed
@@ -365,15 +876,17 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 			userAgent := getUserAgent(postData.Headers)
 			analytic.Platform = utils.Platform(userAgent)
 
-			// Get countryCode from GeoIp
-			analytic.CountryCode, err = geoip.GeoIpLookup(geolite2, postData.Ip)
+			// Get countryCode from GeoIp if IP is passed
+			if len(postData.Ip) > 0 {
+				analytic.CountryCode, err = geoip.GeoIpLookup(geolite2, postData.Ip)
+			}
 
 			// Construct Params object
 			params := database.Params{
 				DBName: dbName,
 			}
 
-			err = driver.Insert(params, analytic)
+			err = driver.Insert(req.Context(), params, analytic)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
@@ -408,27 +921,58 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				return
 			}
 
-			// Create map of analytics for Bulk insert
-			analytics := make(map[string][]database.Analytic, 1)
+			// Derive missing ids from the idempotency key
+			if err := setIdempotencyIds(postList.List, req.Header.Get(idempotencyKeyHeader)); err != nil {
+				renderError(w, err)
+				return
+			}
 
-			for _, postData := range postList.List {
-				// Parse data
-				analytic := parseAnalytic(postData, geolite2, log)
+			// Parse analytics for Bulk insert
+			bulk := newBulkInsert(postList.List, dbName, geolite2, log)
 
-				// Add analytic to list
-				analytics[dbName] = append(analytics[dbName], analytic)
+			// Reject the whole list if any analytic is invalid in strict or atomic mode
+			atomic := req.URL.Query().Get("atomic") == "true"
+			strict := req.URL.Query().Get("strict") == "true" || atomic
+			if strict && len(bulk.result.Rejected) > 0 {
+				renderBulkResult(w, bulk.result, &webErrors.BulkRejected)
+				return
 			}
 
 			// Insert
-			err = driver.BulkInsert(analytics)
+			err = bulk.insert(req.Context(), driver, atomic)
 			if err != nil {
-				renderError(w, normalizeDriverError(err))
+				log.Error("Failed to insert analytics: %#v", bulk.result.Rejected)
+				renderBulkResult(w, bulk.result, err)
 				return
 			}
 
-			log.Info("Successfully inserted analytics: %#v", analytics)
+			log.Info("Successfully inserted analytics: %#v", bulk.analytics)
 
-			render(w, nil, nil)
+			renderBulkResult(w, bulk.result, nil)
+		})
+
+	/////
+	// Push a stream of analytics to a specific DB
+	/////
+	r.Path("/{dbName}/bulk/stream").
+		Methods("POST").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get dbName from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
+			// Insert analytics as they are read
+			result, err := insertStream(req, driver, dbName, geolite2, log)
+			if err != nil {
+				log.Error("Failed to insert stream of analytics: %v", err)
+				renderBulkResult(w, result, err)
+				return
+			}
+
+			log.Info("Successfully inserted %d analytics from stream", result.Accepted)
+
+			renderBulkResult(w, result, nil)
 		})
 
 	/////
@@ -447,7 +991,7 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 				DBName: dbName,
 			}
 
-			err := driver.Delete(params)
+			err := driver.Delete(req.Context(), params)
 			if err != nil {
 				renderError(w, normalizeDriverError(err))
 				return
@@ -456,14 +1000,139 @@ func NewRouter(opts RouterOpts) (http.Handler, error) {
 			render(w, nil, nil)
 		})
 
-	return r, nil
+	// Use authentication if username provided
+	var handler http.Handler = r
+	if opts.Auth != nil && len(opts.Auth.Name) > 0 {
+		handler = BasicAuthMiddleware(opts.Auth, handler)
+	}
+
+	// Collection routes are public since they are requested by visitors
+	public := mux.NewRouter()
+
+	/////
+	// Serve the JavaScript tracker
+	// It sends analytics to the collection routes rather than POST /{dbName},
+	// which requires credentials and the headers of the visitor
+	/////
+	public.Path("/{dbName}/tracker.js").
+		Methods("GET").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			serveTrackerScript(w, req, trackerScript)
+		})
+
+	/////
+	// Collect an analytic from a tracking pixel
+	/////
+	public.Path("/{dbName}/pixel.gif").
+		Methods("GET").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get dbName from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
+			// Only collect analytics of existing websites
+			exists, err := collectedWebsite(opts.DriverOpts.Directory, dbName)
+			if err != nil {
+				renderError(w, &webErrors.InternalError)
+				return
+			}
+			if !exists {
+				renderError(w, &webErrors.UnknownWebsite)
+				return
+			}
+
+			// Parse request query
+			if err := req.ParseForm(); err != nil {
+				renderError(w, &webErrors.InvalidForm)
+				return
+			}
+
+			// Parse data
+			analytic, err := ParseAnalytic(collectedAnalytic(req, opts.TrustedProxyHeaders, opts.TrustedProxies), geolite2, log)
+			if err != nil {
+				renderError(w, err)
+				return
+			}
+
+			// Construct Params object
+			params := database.Params{
+				DBName: dbName,
+			}
+
+			err = driver.Insert(req.Context(), params, analytic)
+			if err != nil {
+				renderError(w, normalizeDriverError(err))
+				return
+			}
+
+			// Prevent browsers from caching the pixel
+			w.Header().Set("Content-Type", "image/gif")
+			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+			w.Write(pixelGIF)
+		})
+
+	/////
+	// Collect an analytic sent by navigator.sendBeacon
+	/////
+	public.Path("/{dbName}/beacon").
+		Methods("POST").
+		HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+			// Get dbName from URL
+			vars := mux.Vars(req)
+			dbName := vars["dbName"]
+
+			// Only collect analytics of existing websites
+			exists, err := collectedWebsite(opts.DriverOpts.Directory, dbName)
+			if err != nil {
+				renderError(w, &webErrors.InternalError)
+				return
+			}
+			if !exists {
+				renderError(w, &webErrors.UnknownWebsite)
+				return
+			}
+
+			// Parse request query and form body
+			if err := req.ParseForm(); err != nil {
+				renderError(w, &webErrors.InvalidForm)
+				return
+			}
+
+			// Parse data
+			analytic, err := ParseAnalytic(collectedAnalytic(req, opts.TrustedProxyHeaders, opts.TrustedProxies), geolite2, log)
+			if err != nil {
+				renderError(w, err)
+				return
+			}
+
+			// Construct Params object
+			params := database.Params{
+				DBName: dbName,
+			}
+
+			err = driver.Insert(req.Context(), params, analytic)
+			if err != nil {
+				renderError(w, normalizeDriverError(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+	public.PathPrefix("/").Handler(handler)
+
+	return public, nil
 }
 
-// parseAnalytic takes a structures.PostAnalytic from a POST request
+// ParseAnalytic takes a structures.PostAnalytic from a POST request
 // and returns a database.Analytic ready struct to feed the driver
-func parseAnalytic(postData PostAnalytic, geolite2 *maxminddb.Reader, log *logger.Logger) database.Analytic {
+// An error is returned if the time or IP of the analytic is invalid
+func ParseAnalytic(postData PostAnalytic, geolite2 *maxminddb.Reader, log *logger.Logger) (database.Analytic, error) {
 	// Create Analytic to inject in DB
 	analytic := database.Analytic{
+		Id:            postData.Id,
 		Time:          time.Now(),
 		Event:         postData.Event,
 		Path:          postData.Path,
@@ -471,6 +1140,9 @@ func parseAnalytic(postData PostAnalytic, geolite2 *maxminddb.Reader, log *logge
 		Platform:      postData.Platform,
 		RefererDomain: postData.RefererDomain,
 		CountryCode:   postData.CountryCode,
+		UserId:        postData.UserId,
+		SessionId:     postData.SessionId,
+		Properties:    postData.Properties,
 	}
 
 	var err error
@@ -479,12 +1151,16 @@ func parseAnalytic(postData PostAnalytic, geolite2 *maxminddb.Reader, log *logge
 		// Try to parse time as an RFC format or a Unix timestamp
 		analytic.Time, err = parseTime(postData.Time)
 		if err != nil {
-			// Reset to current time if format is invalid
-			analytic.Time = time.Now()
+			return analytic, &webErrors.InvalidAnalyticTime
 		}
 	}
 	analytic.Time = analytic.Time.UTC()
 
+	// Check IP format if passed
+	if len(postData.Ip) > 0 && net.ParseIP(postData.Ip) == nil {
+		return analytic, &webErrors.InvalidAnalyticIp
+	}
+
 	// Use headers if provided
 	if len(postData.Headers) > 0 {
 		// Set analytic referer domain
@@ -502,13 +1178,24 @@ func parseAnalytic(postData PostAnalytic, geolite2 *maxminddb.Reader, log *logge
 		}
 	}
 
-	// Get countryCode from GeoIp
-	analytic.CountryCode, err = geoip.GeoIpLookup(geolite2, postData.Ip)
-	if err != nil {
-		log.Error("Error [%v] looking for countryCode for IP %s", postData.Ip)
+	// Get countryCode from GeoIp unless passed
+	if analytic.CountryCode == "" && len(postData.Ip) > 0 {
+		analytic.CountryCode, err = geoip.GeoIpLookup(geolite2, postData.Ip)
+		if err != nil {
+			log.Error("Error [%v] looking for countryCode for IP %s", err, postData.Ip)
+		}
 	}
 
-	return analytic
+	return analytic, nil
+}
+
+// Derive the context of a driver query from a request
+// Queries are canceled when the client disconnects or after timeout if positive
+func queryContext(req *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(req.Context(), timeout)
+	}
+	return context.WithCancel(req.Context())
 }
 
 // Initialize and validate a TimeRange struct with parameters
@@ -547,6 +1234,124 @@ func newTimeRange(start string, end string) (*database.TimeRange, error) {
 	return &timeRange, nil
 }
 
+// Parse and validate a comma separated list of columns to group by
+func parseGroupByColumns(by string) ([]string, error) {
+	if len(by) == 0 {
+		return nil, errors.New("at least one column is required")
+	}
+
+	properties := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, column := range strings.Split(by, ",") {
+		property, ok := groupByProperty(strings.TrimSpace(column))
+		if !ok {
+			return nil, fmt.Errorf("column '%s' can't be grouped by", column)
+		}
+		if seen[property] {
+			return nil, fmt.Errorf("column '%s' is repeated", column)
+		}
+		seen[property] = true
+		properties = append(properties, property)
+	}
+
+	return properties, nil
+}
+
+// Parse and validate the identifier of unique visitors
+// Defaults to ip
+func parseUniqueBy(uniqueBy string) (string, bool) {
+	if len(uniqueBy) == 0 {
+		return database.UniqueByIp, true
+	}
+	return uniqueBy, allowedUniqueBy[uniqueBy]
+}
+
+// Parse and validate the inactivity timeout of sessions as a number of seconds
+// Defaults to database.DefaultSessionTimeout
+func parseSessionTimeout(timeoutStr string) (time.Duration, error) {
+	if len(timeoutStr) == 0 {
+		return database.DefaultSessionTimeout, nil
+	}
+
+	seconds, err := strconv.Atoi(timeoutStr)
+	if err != nil {
+		return 0, err
+	}
+	if seconds <= 0 {
+		return 0, errors.New("timeout must be positive")
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// Validate the steps and window of a funnel
+// window defaults to database.DefaultFunnelWindow
+func parseFunnel(postFunnel PostFunnel) ([]database.FunnelStep, time.Duration, error) {
+	if len(postFunnel.Steps) < 2 || len(postFunnel.Steps) > database.MaxFunnelSteps {
+		return nil, 0, fmt.Errorf("a funnel must have 2 to %d steps", database.MaxFunnelSteps)
+	}
+
+	steps := make([]database.FunnelStep, 0, len(postFunnel.Steps))
+	for _, postStep := range postFunnel.Steps {
+		if len(postStep.Event) == 0 && len(postStep.Path) == 0 {
+			return nil, 0, errors.New("a funnel step must have an event or a path")
+		}
+		steps = append(steps, database.FunnelStep{
+			Event: postStep.Event,
+			Path:  postStep.Path,
+		})
+	}
+
+	if postFunnel.Window < 0 {
+		return nil, 0, errors.New("window must be positive")
+	}
+	window := database.DefaultFunnelWindow
+	if postFunnel.Window > 0 {
+		window = time.Duration(postFunnel.Window) * time.Second
+	}
+
+	return steps, window, nil
+}
+
+// Return the DB property of a column allowed to be grouped by
+// Custom properties are grouped by as properties.<key>
+func groupByProperty(column string) (string, bool) {
+	if property, ok := allowedColumns[column]; ok {
+		return property, true
+	}
+	if _, ok := database.CustomPropertyKey(column); ok {
+		return column, true
+	}
+	return "", false
+}
+
+// Parse and validate limit and offset parameters
+// Both default to 0, meaning no limit and no offset
+func parsePagination(limitStr string, offsetStr string) (int, int, error) {
+	limit := 0
+	offset := 0
+
+	var err error
+	if len(limitStr) > 0 {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(offsetStr) > 0 {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if limit < 0 || offset < 0 {
+		return 0, 0, errors.New("limit and offset must be positive")
+	}
+
+	return limit, offset, nil
+}
+
 // Try to parse a time string as RFC3339 or RFC1123 or a Unix timestamp
 func parseTime(timeStr string) (time.Time, error) {
 	// Try to parse as RFC3339
@@ -556,8 +1361,9 @@ func parseTime(timeStr string) (time.Time, error) {
 		timeValue, err = time.Parse(time.RFC1123, timeStr)
 		if err != nil {
 			// Try to parse as a Unix timestamp
-			if intTime, err := strconv.Atoi(timeStr); err == nil {
+			if intTime, atoiErr := strconv.Atoi(timeStr); atoiErr == nil {
 				timeValue = time.Unix(int64(intTime), 0)
+				err = nil
 			}
 		}
 	}
@@ -607,6 +1413,16 @@ func normalizeDriverError(err error) error {
 			return &webErrors.InvalidDatabaseName
 		case 3:
 			return &webErrors.InsertFailed
+		case 4:
+			return &webErrors.QueryTimeout
+		case 5:
+			return &webErrors.QueryCanceled
+		case 6:
+			return &webErrors.InvalidCursor
+		case 7:
+			return &webErrors.TooManyIntervals
+		case 8:
+			return &webErrors.AtomicNotSupported
 		default:
 			return &webErrors.InternalError
 		}
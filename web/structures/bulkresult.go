@@ -0,0 +1,12 @@
+package structures
+
+type BulkRejection struct {
+	Index   int    `json:"index"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type BulkResult struct {
+	Accepted int             `json:"accepted"`
+	Rejected []BulkRejection `json:"rejected"`
+}
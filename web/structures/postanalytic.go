@@ -1,6 +1,7 @@
 package structures
 
 type PostAnalytic struct {
+	Id            string            `json:"id"`
 	Website       string            `json:"website"`
 	Time          string            `json:"time"`
 	Event         string            `json:"event"`
@@ -9,7 +10,11 @@ type PostAnalytic struct {
 	Platform      string            `json:"platform"`
 	RefererDomain string            `json:"refererDomain"`
 	CountryCode   string            `json:"countryCode"`
+	UserId        string            `json:"userId"`
+	SessionId     string            `json:"sessionId"`
 	Headers       map[string]string `json:"headers"`
+
+	Properties map[string]interface{} `json:"properties"`
 }
 
 type PostAnalytics struct {
@@ -1,9 +1,14 @@
 package structures
 
 type PostData struct {
-	Time    string            `json:"time"`
-	Event   string            `json:"event"`
-	Path    string            `json:"path"`
-	Ip      string            `json:"ip"`
-	Headers map[string]string `json:"headers"`
+	Id        string            `json:"id"`
+	Time      string            `json:"time"`
+	Event     string            `json:"event"`
+	Path      string            `json:"path"`
+	Ip        string            `json:"ip"`
+	UserId    string            `json:"userId"`
+	SessionId string            `json:"sessionId"`
+	Headers   map[string]string `json:"headers"`
+
+	Properties map[string]interface{} `json:"properties"`
 }
@@ -0,0 +1,11 @@
+package structures
+
+type PostFunnelStep struct {
+	Event string `json:"event"`
+	Path  string `json:"path"`
+}
+
+type PostFunnel struct {
+	Steps  []PostFunnelStep `json:"steps"`
+	Window int              `json:"window"`
+}